@@ -0,0 +1,165 @@
+package sstable
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/wangkuiyi/sstable/internal/block"
+	"github.com/wangkuiyi/sstable/internal/codec"
+)
+
+// Layout of the block-compressed file Writer.WriteBlockIndex produces,
+// a third alternative to WriteIndex's linear index and
+// WriteCompactIndex's hash index: instead of indexing individual
+// records, it groups them into blocks and indexes those.
+//
+//  1. the v2 header, as usual
+//  2. a sequence of compressed blocks, each holding one or more
+//     records packed as encodeRecordBody does, with no per-record
+//     checksum (the block's own CRC32 covers all of them at once)
+//  3. the block-index entries, one per block, in block order:
+//  1. first-key-size, 4 bytes little-endian uint32
+//  2. first-key
+//  3. offset of the block, 8 bytes little-endian uint64
+//  4. compressed length, 4 bytes little-endian uint32
+//  5. uncompressed length, 4 bytes little-endian uint32
+//  6. crc32.Castagnoli of the block's compressed bytes, 4 bytes
+//     little-endian uint32
+//  4. a footer:
+//  1. blockIndexMagic, 4 bytes little-endian uint32
+//  2. codec id, 1 byte
+//  3. offset of the first block-index entry, 8 bytes little-endian
+//     uint64
+//  4. crc32.Castagnoli of 3 above, 4 bytes little-endian uint32
+//
+// Reader.Open tells this footer apart from the linear and compact
+// indexes' by its distinct magic number, and checks for it first,
+// since WriteBlockIndex is the only one of the three that also changes
+// how records themselves are stored.
+const (
+	blockIndexMagic       uint32 = 0xb10c1bdc
+	blockIndexEndFlagSize int64  = 17 // magic + codec id + entries offset + crc
+
+	// defaultBlockSize is a reasonable WriterOptions.BlockSize for
+	// callers opting into block compression. NewWriterWithOptions never
+	// applies it on its own: a zero or negative BlockSize means "no
+	// block compression".
+	defaultBlockSize = 4 << 10 // 4 KiB of uncompressed record bytes per block
+)
+
+// Fixed, on-disk ids for the codecs this package knows how to resolve
+// by name when writing, and by id when reading, a block index footer.
+const (
+	codecIDNone  byte = 0
+	codecIDFlate byte = 1
+)
+
+// codecID returns the on-disk id WriteBlockIndex records for c's name.
+func codecID(c codec.Codec) (byte, error) {
+	switch c.Name() {
+	case "none":
+		return codecIDNone, nil
+	case "flate":
+		return codecIDFlate, nil
+	}
+	return 0, fmt.Errorf("Unknown codec %q", c.Name())
+}
+
+// codecByID returns the Codec a block index footer's id refers to.
+func codecByID(id byte) (codec.Codec, error) {
+	switch id {
+	case codecIDNone:
+		return codec.None{}, nil
+	case codecIDFlate:
+		return codec.Flate{}, nil
+	}
+	return nil, fmt.Errorf("Unknown codec id %d", id)
+}
+
+// newBlockWriter is NewWriterWithOptions' block-compressed path: it
+// writes the usual v2 header, then hands every subsequent Put and
+// Delete to a block.Writer instead of an indexBuilder.
+func newBlockWriter(w io.Writer, opts WriterOptions) (*Writer, error) {
+	c := opts.Codec
+	if c == nil {
+		c = codec.None{}
+	}
+	if _, e := codecID(c); e != nil {
+		return nil, fmt.Errorf("Failed to create block writer: %v", e)
+	}
+
+	ss := &Writer{
+		Writer: w,
+		offset: headerSize,
+		codec:  c,
+	}
+	if e := writeHeader(ss); e != nil {
+		return nil, fmt.Errorf("Failed to write file header: %v", e)
+	}
+
+	ss.blocks = block.NewWriter(ss, c, opts.BlockSize, ss.offset)
+	return ss, nil
+}
+
+// WriteBlockIndex flushes the final, possibly short, block, appends
+// the block-index entries and footer described above, and finalizes
+// the file. It must be called instead of WriteIndex on a Writer
+// returned for a positive WriterOptions.BlockSize, and not at all
+// otherwise.
+func (ss *Writer) WriteBlockIndex() error {
+	if ss.blocks == nil {
+		return fmt.Errorf("WriteBlockIndex called on a non-block Writer; call WriteIndex or WriteCompactIndex instead")
+	}
+
+	if e := ss.blocks.Flush(); e != nil {
+		return fmt.Errorf("Failed to flush final block: %v", e)
+	}
+	entriesStart := ss.blocks.Offset()
+
+	id, e := codecID(ss.codec)
+	if e != nil {
+		return fmt.Errorf("Failed to resolve block codec id: %v", e)
+	}
+
+	crcHash := crc32.New(crcTable)
+	mw := io.MultiWriter(ss, crcHash)
+
+	for _, en := range ss.blocks.Entries() {
+		if e := writeUint32(mw, len(en.FirstKey)); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+		if _, e := io.WriteString(mw, en.FirstKey); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+		if e := writeUint64(mw, en.Offset); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+		if e := writeUint32(mw, int(en.CompressedLen)); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+		if e := writeUint32(mw, int(en.UncompressedLen)); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+		if e := writeUint32(mw, int(en.CRC32)); e != nil {
+			return fmt.Errorf("Failed to write block index entry: %v", e)
+		}
+	}
+
+	if e := writeUint32(ss, int(blockIndexMagic)); e != nil {
+		return fmt.Errorf("Failed to write block index magic: %v", e)
+	}
+	if _, e := ss.Write([]byte{id}); e != nil {
+		return fmt.Errorf("Failed to write block index codec id: %v", e)
+	}
+	if e := writeUint64(ss, entriesStart); e != nil {
+		return fmt.Errorf("Failed to write block index entries offset: %v", e)
+	}
+	if e := writeUint32(ss, int(crcHash.Sum32())); e != nil {
+		return fmt.Errorf("Failed to write block index checksum: %v", e)
+	}
+
+	// Note: No more content can be added once we wrote the index.
+	ss.Writer = nil
+	return nil
+}
@@ -0,0 +1,296 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/wangkuiyi/sstable/internal/codec"
+)
+
+// buildBlockRecords writes sortedRecords into w through a
+// block-compressed Writer built with opts and returns it, leaving the
+// caller to call WriteBlockIndex. It uses sortedRecords, not records,
+// because Put and Delete require non-decreasing key order in
+// block-compressed mode (see WriterOptions.BlockSize).
+func buildBlockRecords(w io.Writer, opts WriterOptions) *Writer {
+	ww, e := NewWriterWithOptions(w, opts)
+	must(e)
+	for _, kv := range sortedRecords {
+		must(ww.Put(kv[0], kv[1]))
+	}
+	return ww
+}
+
+// sortedRecords is records' keys in ascending order, with no key
+// repeated: Get's binary search over block first keys only answers
+// correctly when Put sees keys in this order (see
+// WriterOptions.BlockSize), so Get tests use this fixture instead of
+// records.
+var sortedRecords = [][2]string{
+	{"apple", "pie"},
+	{"banana", "split"},
+	{"cherry", "pick"},
+}
+
+func TestBlockIndexRoundtrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		codec codec.Codec
+	}{
+		// BlockSize 1 forces every record into its own block.
+		{name: "none codec, one record per block", codec: codec.None{}},
+		{name: "flate codec, one record per block", codec: codec.Flate{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ww, e := NewWriterWithOptions(&buf, WriterOptions{BlockSize: 1, Codec: c.codec})
+			must(e)
+			for _, kv := range sortedRecords {
+				must(ww.Put(kv[0], kv[1]))
+			}
+			must(ww.WriteBlockIndex())
+
+			r, e := Open(bytes.NewReader(buf.Bytes()))
+			if e != nil {
+				t.Fatalf("Open failed: %v", e)
+			}
+			if !r.hasBlockIndex {
+				t.Fatalf("Open did not detect the block index footer")
+			}
+			if len(r.blockEntries) != len(sortedRecords) {
+				t.Fatalf("got %d blocks; want %d, one per record", len(r.blockEntries), len(sortedRecords))
+			}
+
+			for _, kv := range sortedRecords {
+				if v, e := r.Get(kv[0]); e != nil || v != kv[1] {
+					t.Errorf("Get(%s) = %q, %v; want %s, nil", kv[0], v, e, kv[1])
+				}
+			}
+			if _, e := r.Get("missing"); e == nil {
+				t.Errorf("Get(missing) returned no error")
+			}
+			if _, e := r.GetAll("apple"); e == nil {
+				t.Errorf("GetAll returned no error on a block-indexed file")
+			}
+
+			it := r.Iterator()
+			var got [][2]string
+			for it.Next() {
+				got = append(got, [2]string{it.Key(), it.Value()})
+			}
+			if e := it.Err(); e != nil {
+				t.Fatalf("Iterator error: %v", e)
+			}
+			if !reflect.DeepEqual(got, sortedRecords) {
+				t.Errorf("Iterator yielded %v; want %v", got, sortedRecords)
+			}
+		})
+	}
+}
+
+// duplicateKeyAcrossBlocks is a non-decreasing fixture (required by
+// Writer.Put/Delete in block-compressed mode) that still repeats a key:
+// "apple" is written twice, landing in two different blocks once
+// BlockSize 1 forces a flush between them.
+var duplicateKeyAcrossBlocks = [][2]string{
+	{"apple", "pie"},
+	{"apple", "imac"},
+	{"banana", "split"},
+}
+
+// TestBlockIndexIteratorAcrossBlocks exercises Iterator, specifically,
+// with a duplicate key split across blocks: Get returns only its
+// latest write, but both writes must still be reachable by a full-file
+// scan.
+func TestBlockIndexIteratorAcrossBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	ww, e := NewWriterWithOptions(&buf, WriterOptions{BlockSize: 1})
+	must(e)
+	for _, kv := range duplicateKeyAcrossBlocks {
+		must(ww.Put(kv[0], kv[1]))
+	}
+	must(ww.WriteBlockIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	if len(r.blockEntries) != len(duplicateKeyAcrossBlocks) {
+		t.Fatalf("got %d blocks; want %d, one per record", len(r.blockEntries), len(duplicateKeyAcrossBlocks))
+	}
+
+	it := r.Iterator()
+	var got [][2]string
+	for it.Next() {
+		got = append(got, [2]string{it.Key(), it.Value()})
+	}
+	if e := it.Err(); e != nil {
+		t.Fatalf("Iterator error: %v", e)
+	}
+	if !reflect.DeepEqual(got, duplicateKeyAcrossBlocks) {
+		t.Errorf("Iterator yielded %v; want %v (file order, both apple writes reachable across block boundaries)", got, duplicateKeyAcrossBlocks)
+	}
+}
+
+func TestBlockIndexDefaultCodec(t *testing.T) {
+	var buf bytes.Buffer
+	ww, e := NewWriterWithOptions(&buf, WriterOptions{BlockSize: defaultBlockSize})
+	must(e)
+	for _, kv := range duplicateKeyAcrossBlocks {
+		must(ww.Put(kv[0], kv[1]))
+	}
+	must(ww.WriteBlockIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	if v, e := r.Get("apple"); e != nil || v != "imac" {
+		t.Errorf("Get(apple) = %q, %v; want imac, nil", v, e)
+	}
+}
+
+func TestBlockIndexTombstone(t *testing.T) {
+	var buf bytes.Buffer
+	ww, e := NewWriterWithOptions(&buf, WriterOptions{BlockSize: 1, Codec: codec.Flate{}})
+	must(e)
+	// apple's delete must come before banana's Put to keep block first
+	// keys in the non-decreasing order Get's binary search requires.
+	must(ww.Put("apple", "pie"))
+	must(ww.Delete("apple"))
+	must(ww.Put("banana", "split"))
+	must(ww.WriteBlockIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	if _, e := r.Get("apple"); e == nil {
+		t.Errorf("Get(apple) returned no error after Delete")
+	}
+	if v, e := r.Get("banana"); e != nil || v != "split" {
+		t.Errorf("Get(banana) = %q, %v; want split, nil", v, e)
+	}
+}
+
+func TestBlockIndexManyKeys(t *testing.T) {
+	var buf bytes.Buffer
+	ww, e := NewWriterWithOptions(&buf, WriterOptions{BlockSize: defaultBlockSize, Codec: codec.Flate{}})
+	must(e)
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		must(ww.Put(fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i)))
+	}
+	must(ww.WriteBlockIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	if len(r.blockEntries) <= 1 {
+		t.Fatalf("got %d blocks; want more than one for %d keys", len(r.blockEntries), n)
+	}
+
+	for i := 0; i < n; i++ {
+		key, want := fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i)
+		if v, e := r.Get(key); e != nil || v != want {
+			t.Fatalf("Get(%s) = %q, %v; want %s, nil", key, v, e, want)
+		}
+	}
+	if _, e := r.Get("key-missing"); e == nil {
+		t.Errorf("Get(key-missing) returned no error")
+	}
+}
+
+func TestBlockIndexRejectsOutOfOrderPut(t *testing.T) {
+	ww, e := NewWriterWithOptions(io.Discard, WriterOptions{BlockSize: defaultBlockSize})
+	must(e)
+	must(ww.Put("zebra", "stripes"))
+
+	if e := ww.Put("apple", "pie"); e == nil {
+		t.Errorf("Put did not reject a key out of non-decreasing order")
+	}
+}
+
+func TestBlockIndexDetectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	ww := buildBlockRecords(&buf, WriterOptions{BlockSize: 1, Codec: codec.None{}})
+	must(ww.WriteBlockIndex())
+
+	bs := buf.Bytes()
+	bs[int64(len(bs))-blockIndexEndFlagSize-1] ^= 0xff // flip a bit in a block index entry.
+
+	var ce *ErrCorrupt
+	if _, e := Open(bytes.NewReader(bs)); e == nil {
+		t.Fatalf("Open did not detect the corrupted block index")
+	} else if !errors.As(e, &ce) {
+		t.Errorf("Open error = %v; want *ErrCorrupt", e)
+	}
+}
+
+func TestBlockContentDetectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	ww := buildBlockRecords(&buf, WriterOptions{BlockSize: 1, Codec: codec.None{}})
+	must(ww.WriteBlockIndex())
+
+	bs := buf.Bytes()
+	bs[headerSize] ^= 0xff // flip a bit in the first block's compressed bytes.
+
+	r, e := Open(bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+
+	var ce *ErrCorrupt
+	if _, e := r.Get(sortedRecords[0][0]); e == nil {
+		t.Fatalf("Get did not detect the corrupted block")
+	} else if !errors.As(e, &ce) {
+		t.Errorf("Get error = %v; want *ErrCorrupt", e)
+	}
+}
+
+func TestWriteBlockIndexRejectsNonBlockWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, e := Create(&buf)
+	must(e)
+	must(w.Put("apple", "pie"))
+
+	if e := w.WriteBlockIndex(); e == nil {
+		t.Errorf("WriteBlockIndex did not reject a non-block Writer")
+	}
+}
+
+func TestWriteIndexRejectsBlockWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := buildBlockRecords(&buf, WriterOptions{BlockSize: defaultBlockSize})
+
+	if e := w.WriteIndex(); e == nil {
+		t.Errorf("WriteIndex did not reject a block-compressed Writer")
+	}
+	if e := w.WriteCompactIndex(); e == nil {
+		t.Errorf("WriteCompactIndex did not reject a block-compressed Writer")
+	}
+}
+
+// BenchmarkWriterBlockIndex is BenchmarkWriterSpoolIndex's
+// block-compressed counterpart.
+func BenchmarkWriterBlockIndex(b *testing.B) {
+	w, e := NewWriterWithOptions(io.Discard, WriterOptions{BlockSize: defaultBlockSize, Codec: codec.Flate{}})
+	must(e)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Zero-padded so keys arrive in the non-decreasing order Put
+		// requires in block-compressed mode.
+		must(w.Put(fmt.Sprintf("key-%010d", i), "value"))
+	}
+	b.StopTimer()
+	must(w.blocks.Flush())
+}
@@ -0,0 +1,263 @@
+package sstable
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Layout of the compact index Writer.WriteCompactIndex appends, an
+// alternative to the linear key->offsets block WriteIndex writes:
+//
+//  1. a bucket header table, right after the file's records, one
+//     16-byte entry per bucket:
+//  1. seed, 4 bytes little-endian uint32
+//  2. entry-offset, 8 bytes little-endian uint64
+//  3. num-entries, 4 bytes little-endian uint32
+//  2. for every bucket, its entries, 16 bytes each, at entry-offset:
+//  1. key-hash, 8 bytes little-endian uint64
+//  2. value-offset, 8 bytes little-endian uint64
+//  3. a footer:
+//  1. compactMagic, 4 bytes little-endian uint32
+//  2. number of buckets, 4 bytes little-endian uint32
+//  3. offset of the bucket header table, 8 bytes little-endian uint64
+//  4. crc32.Castagnoli of 1 and 2 above, 4 bytes little-endian uint32
+//
+// Reader.Open tells the compact index's footer apart from the linear
+// index's (separator + 8-byte offset) by its distinct magic number.
+//
+// Each bucket holds, on average, targetBucketLoad keys. Its entries
+// are placed at slots computed by a per-bucket minimal perfect hash
+// function: the smallest seed, found by brute-force probing, for
+// which hashing every key in the bucket (mixed with that seed) lands
+// on a distinct slot in [0, len(bucket)). Reader.LookupCompact
+// recomputes the same slot to do a lookup in two seeks into the index,
+// plus one to read and verify the record.
+const (
+	compactMagic            uint32 = 0xc0c1dec1
+	compactIndexEndFlagSize int64  = 20 // magic + bucket count + header-table offset + crc
+	compactBucketHeaderSize int64  = 16 // seed + entry-offset + num-entries
+	compactEntrySize        int64  = 16 // key-hash + value-offset
+
+	targetBucketLoad = 4       // average keys per bucket
+	maxSeedAttempts  = 1 << 16 // bound on MPHF seed probing per bucket
+	maxBucketRetries = 8       // bound on doubling the bucket count after a stuck bucket
+)
+
+// WriteCompactIndex appends a constant-time, on-disk hash index built
+// from every key this Writer has seen, as an alternative to the linear
+// index WriteIndex writes: Reader.LookupCompact answers a Get with two
+// seeks into the index, one more to read the record, and no in-memory
+// index at all, at the cost of being unable to
+// enumerate keys or return more than the latest value per key.
+func (ss *Writer) WriteCompactIndex() error {
+	if ss.idx == nil {
+		return fmt.Errorf("WriteCompactIndex called on a block-compressed Writer; call WriteBlockIndex instead")
+	}
+
+	headerStart := ss.offset
+
+	keys, e := ss.collectLatest()
+	if e != nil {
+		return fmt.Errorf("Failed to collect keys for compact index: %v", e)
+	}
+
+	buckets, e := buildCompactBuckets(keys)
+	if e != nil {
+		return fmt.Errorf("Failed to build compact index: %v", e)
+	}
+
+	crcHash := crc32.New(crcTable)
+	mw := io.MultiWriter(ss, crcHash)
+
+	entryOffset := headerStart + int64(len(buckets))*compactBucketHeaderSize
+	for _, bkt := range buckets {
+		if e := writeUint32(mw, int(bkt.seed)); e != nil {
+			return fmt.Errorf("Failed to write bucket header: %v", e)
+		}
+		if e := writeUint64(mw, entryOffset); e != nil {
+			return fmt.Errorf("Failed to write bucket header: %v", e)
+		}
+		if e := writeUint32(mw, len(bkt.entries)); e != nil {
+			return fmt.Errorf("Failed to write bucket header: %v", e)
+		}
+		entryOffset += int64(len(bkt.entries)) * compactEntrySize
+	}
+	for _, bkt := range buckets {
+		for _, en := range bkt.entries {
+			if e := writeUint64(mw, int64(en.keyHash)); e != nil {
+				return fmt.Errorf("Failed to write bucket entry: %v", e)
+			}
+			if e := writeUint64(mw, en.offset); e != nil {
+				return fmt.Errorf("Failed to write bucket entry: %v", e)
+			}
+		}
+	}
+
+	if e := writeUint32(ss, int(compactMagic)); e != nil {
+		return fmt.Errorf("Failed to write compact index magic: %v", e)
+	}
+	if e := writeUint32(ss, len(buckets)); e != nil {
+		return fmt.Errorf("Failed to write bucket count: %v", e)
+	}
+	if e := writeUint64(ss, headerStart); e != nil {
+		return fmt.Errorf("Failed to write bucket header table offset: %v", e)
+	}
+	if e := writeUint32(ss, int(crcHash.Sum32())); e != nil {
+		return fmt.Errorf("Failed to write compact index checksum: %v", e)
+	}
+
+	closeErr := ss.idx.close()
+
+	// Note: No more content can be added once we wrote the index.
+	ss.Writer = nil
+
+	if closeErr != nil {
+		return fmt.Errorf("Failed to release index builder resources: %v", closeErr)
+	}
+	return nil
+}
+
+// compactKeyOffset is one key and the latest offset Put recorded for
+// it.
+type compactKeyOffset struct {
+	key    string
+	offset int64
+}
+
+// collectLatest drains ss.idx's groups into a flat list of (key, the
+// last offset Put recorded for it) pairs, which is all
+// Reader.LookupCompact can return per key.
+func (ss *Writer) collectLatest() ([]compactKeyOffset, error) {
+	src, e := ss.idx.groups()
+	if e != nil {
+		return nil, e
+	}
+	defer src.close()
+
+	var keys []compactKeyOffset
+	for {
+		key, offsets, ok, e := src.next()
+		if e != nil {
+			return nil, e
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, compactKeyOffset{key: key, offset: offsets[len(offsets)-1]})
+	}
+	return keys, nil
+}
+
+// compactEntry is one bucket slot: the full key's hash, so
+// Reader.LookupCompact can reject an obviously-wrong probe without a
+// seek, and the offset of its latest record.
+type compactEntry struct {
+	keyHash uint64
+	offset  int64
+}
+
+// compactBucket is a bucket's minimal perfect hash seed and its
+// entries, already placed at their slots.
+type compactBucket struct {
+	seed    uint32
+	entries []compactEntry
+}
+
+// buildCompactBuckets partitions keys into buckets by hash and builds
+// a minimal perfect hash function for each bucket, retrying with more,
+// smaller buckets if any bucket's construction doesn't terminate
+// within maxSeedAttempts.
+func buildCompactBuckets(keys []compactKeyOffset) ([]compactBucket, error) {
+	n := len(keys) / targetBucketLoad
+	if n < 1 {
+		n = 1
+	}
+
+	for attempt := 0; attempt < maxBucketRetries; attempt++ {
+		grouped := make([][]compactKeyOffset, n)
+		for _, k := range keys {
+			b := bucketHash(k.key) % uint64(n)
+			grouped[b] = append(grouped[b], k)
+		}
+
+		buckets := make([]compactBucket, n)
+		stuck := false
+		for i, group := range grouped {
+			seed, ok := findBucketSeed(group)
+			if !ok {
+				stuck = true
+				break
+			}
+			entries := make([]compactEntry, len(group))
+			for _, k := range group {
+				slot := slotHash(seed, k.key) % uint64(len(group))
+				entries[slot] = compactEntry{keyHash: bucketHash(k.key), offset: k.offset}
+			}
+			buckets[i] = compactBucket{seed: seed, entries: entries}
+		}
+
+		if !stuck {
+			return buckets, nil
+		}
+		n = n*2 + 1
+	}
+
+	return nil, fmt.Errorf("could not find a minimal perfect hash after growing to %d buckets", n)
+}
+
+// findBucketSeed probes seeds 0, 1, 2, ... until every key in group
+// lands on a distinct slot in [0, len(group)), or gives up after
+// maxSeedAttempts.
+func findBucketSeed(group []compactKeyOffset) (uint32, bool) {
+	if len(group) == 0 {
+		return 0, true
+	}
+
+	used := make([]bool, len(group))
+	for seed := uint32(0); seed < maxSeedAttempts; seed++ {
+		for i := range used {
+			used[i] = false
+		}
+
+		collided := false
+		for _, k := range group {
+			slot := slotHash(seed, k.key) % uint64(len(group))
+			if used[slot] {
+				collided = true
+				break
+			}
+			used[slot] = true
+		}
+		if !collided {
+			return seed, true
+		}
+	}
+	return 0, false
+}
+
+// bucketHash and slotHash are both FNV-1a over the key, differing only
+// in their starting basis: bucketHash is used to assign keys to
+// buckets and to verify a candidate match, while slotHash additionally
+// mixes in a per-bucket seed to place keys within their bucket.
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+func bucketHash(key string) uint64 {
+	return fnvHash(fnvOffset64, key)
+}
+
+func slotHash(seed uint32, key string) uint64 {
+	return fnvHash(fnvOffset64^uint64(seed), key)
+}
+
+func fnvHash(basis uint64, key string) uint64 {
+	h := basis
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
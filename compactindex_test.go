@@ -0,0 +1,96 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCompactIndexRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	ww, _ := buildRecords(&buf)
+	must(ww.WriteCompactIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	if !r.hasCompactIndex {
+		t.Fatalf("Open did not detect the compact index footer")
+	}
+
+	if v, e := r.Get("banana"); e != nil || v != "split" {
+		t.Errorf("Get(banana) = %q, %v; want split, nil", v, e)
+	}
+	if v, e := r.Get("apple"); e != nil || v != "imac" {
+		t.Errorf("Get(apple) = %q, %v; want imac (the latest write), nil", v, e)
+	}
+	if _, e := r.Get("missing"); e == nil {
+		t.Errorf("Get(missing) returned no error")
+	}
+
+	if v, e := r.LookupCompact("banana"); e != nil || v != "split" {
+		t.Errorf("LookupCompact(banana) = %q, %v; want split, nil", v, e)
+	}
+	if _, e := r.GetAll("apple"); e == nil {
+		t.Errorf("GetAll returned no error on a compact-indexed file")
+	}
+}
+
+func TestCompactIndexManyKeys(t *testing.T) {
+	var buf bytes.Buffer
+	ww, e := Create(&buf)
+	must(e)
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		must(ww.Put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+	}
+	must(ww.WriteCompactIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+
+	for i := 0; i < n; i++ {
+		key, want := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		if v, e := r.Get(key); e != nil || v != want {
+			t.Fatalf("Get(%s) = %q, %v; want %s, nil", key, v, e, want)
+		}
+	}
+	if _, e := r.Get("key-missing"); e == nil {
+		t.Errorf("Get(key-missing) returned no error")
+	}
+}
+
+func TestCompactIndexDetectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	ww, _ := buildRecords(&buf)
+	must(ww.WriteCompactIndex())
+
+	bs := buf.Bytes()
+	bs[int64(len(bs))-compactIndexEndFlagSize-1] ^= 0xff // flip a bit in a bucket entry.
+
+	var ce *ErrCorrupt
+	if _, e := Open(bytes.NewReader(bs)); e == nil {
+		t.Fatalf("Open did not detect the corrupted compact index")
+	} else if !errors.As(e, &ce) {
+		t.Errorf("Open error = %v; want *ErrCorrupt", e)
+	}
+}
+
+func TestFindBucketSeedTrivialGroups(t *testing.T) {
+	// A single-entry group is trivially perfect with seed 0, and an
+	// empty group takes the early-return path.
+	group := []compactKeyOffset{{key: "only", offset: 0}}
+	seed, ok := findBucketSeed(group)
+	if !ok || seed != 0 {
+		t.Errorf("findBucketSeed(single entry) = %d, %v; want 0, true", seed, ok)
+	}
+
+	if _, ok := findBucketSeed(nil); !ok {
+		t.Errorf("findBucketSeed(empty group) = _, false; want true")
+	}
+}
@@ -0,0 +1,488 @@
+package sstable
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/wangkuiyi/sstable/internal/codec"
+)
+
+// DefaultSortBufferBytes bounds how many spooled (key, offset) tuples
+// a spoolIndexBuilder sorts in memory at once when it has no
+// WriterOptions.SortBufferBytes of its own.
+const DefaultSortBufferBytes int64 = 64 << 20 // 64 MiB
+
+// WriterOptions configures NewWriterWithOptions.
+type WriterOptions struct {
+	// SpoolDir is the directory in which the index spool and its
+	// sorted runs are created. Empty means os.TempDir().
+	SpoolDir string
+
+	// SortBufferBytes bounds how much of the spool is sorted in
+	// memory at once when building a sorted run. Zero or negative
+	// means DefaultSortBufferBytes.
+	SortBufferBytes int64
+
+	// BlockSize, if positive, switches NewWriterWithOptions to the
+	// block-compressed layout: records are grouped into BlockSize-byte
+	// blocks, each compressed with Codec, instead of being indexed one
+	// by one. SpoolDir and SortBufferBytes are ignored in this mode;
+	// Writer.WriteBlockIndex, not WriteIndex, finalizes the file. Put
+	// and Delete require non-decreasing key order (e.g. because records
+	// came from Merge) and return an error otherwise, since Get finds a
+	// block by binary-searching first keys; Iterator has no such
+	// requirement and sees every record regardless.
+	BlockSize int
+
+	// Codec compresses each block when BlockSize is positive. Nil
+	// means codec.None{}, which groups records into blocks without
+	// compressing them.
+	Codec codec.Codec
+}
+
+// indexBuilder accumulates the (key, offset) pairs a Writer records as
+// it calls Put, and later turns them into the key->offsets index block
+// that WriteIndex appends to the file.
+type indexBuilder interface {
+	// add records that key was written at offset.
+	add(key string, offset int64) error
+
+	// writeBlock writes the index body -- the separator, the number
+	// of unique keys, and then each key followed by its offsets -- to
+	// w. memIndexBuilder writes keys in map order; spoolIndexBuilder
+	// writes them in ascending key order, a side effect of the merge
+	// it already has to do.
+	writeBlock(w io.Writer) error
+
+	// groups returns every (key, offsets) pair this builder has seen,
+	// in whatever order the builder produces them. WriteCompactIndex
+	// uses this instead of writeBlock to build its hash buckets rather
+	// than a linear key->offsets block.
+	groups() (groupSource, error)
+
+	// close releases any resource (e.g. temp files) the builder holds.
+	close() error
+}
+
+// groupSource yields (key, offsets) pairs one at a time.
+type groupSource interface {
+	next() (key string, offsets []int64, ok bool, err error)
+	close()
+}
+
+// memIndexBuilder keeps the whole index in a Go map, exactly like the
+// original Writer did. It is fine for SSTables small enough to fit in
+// RAM, which is what Create is for.
+type memIndexBuilder struct {
+	index map[string][]int64
+}
+
+func newMemIndexBuilder() *memIndexBuilder {
+	return &memIndexBuilder{index: make(map[string][]int64)}
+}
+
+func (b *memIndexBuilder) add(key string, offset int64) error {
+	b.index[key] = append(b.index[key], offset)
+	return nil
+}
+
+func (b *memIndexBuilder) writeBlock(w io.Writer) error {
+	if e := writeUint32(w, int(separator)); e != nil {
+		return fmt.Errorf("Failed to write the separator: %v", e)
+	}
+	if e := writeUint32(w, len(b.index)); e != nil {
+		return fmt.Errorf("Failed to write the number of unique keys: %v", e)
+	}
+	for k, offsets := range b.index {
+		if e := writeIndexEntry(w, k, offsets); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (b *memIndexBuilder) groups() (groupSource, error) {
+	keys := make([]string, 0, len(b.index))
+	for k := range b.index {
+		keys = append(keys, k)
+	}
+	return &mapGroupSource{keys: keys, index: b.index}, nil
+}
+
+func (b *memIndexBuilder) close() error { return nil }
+
+// mapGroupSource walks memIndexBuilder.index's keys, in the order
+// collected when the source was created.
+type mapGroupSource struct {
+	keys  []string
+	index map[string][]int64
+	pos   int
+}
+
+func (s *mapGroupSource) next() (key string, offsets []int64, ok bool, e error) {
+	if s.pos >= len(s.keys) {
+		return "", nil, false, nil
+	}
+	key = s.keys[s.pos]
+	s.pos++
+	return key, s.index[key], true, nil
+}
+
+func (s *mapGroupSource) close() {}
+
+func writeIndexEntry(w io.Writer, key string, offsets []int64) error {
+	if e := writeUint32(w, len(key)); e != nil {
+		return fmt.Errorf("Failed to write key size in index: %v", e)
+	}
+	if _, e := io.WriteString(w, key); e != nil {
+		return fmt.Errorf("Failed to write key in index: %v", e)
+	}
+	if e := writeUint32(w, len(offsets)); e != nil {
+		return fmt.Errorf("Failed to write number of values of key %s: %v", key, e)
+	}
+	for _, o := range offsets {
+		if e := writeUint64(w, o); e != nil {
+			return fmt.Errorf("Failed to write offset of key %s: %v", key, e)
+		}
+	}
+	return nil
+}
+
+// spoolIndexBuilder borrows the "spool the postings offset table on
+// the side" technique from Prometheus TSDB: instead of growing a
+// map[string][]int64 for every Put, it appends each (key, offset)
+// tuple to a temp file, and only sorts and groups them, by an external
+// k-way merge of sorted runs, when WriteIndex asks for the finished
+// block. This bounds memory to SortBufferBytes regardless of how many
+// records are written.
+type spoolIndexBuilder struct {
+	dir             string
+	sortBufferBytes int64
+
+	spoolPath   string
+	spool       *os.File
+	spoolWriter *bufio.Writer
+
+	sorted   bool
+	runPaths []string
+}
+
+func newSpoolIndexBuilder(opts WriterOptions) (*spoolIndexBuilder, error) {
+	dir := opts.SpoolDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	budget := opts.SortBufferBytes
+	if budget <= 0 {
+		budget = DefaultSortBufferBytes
+	}
+
+	f, e := os.CreateTemp(dir, "sstable-spool-*")
+	if e != nil {
+		return nil, e
+	}
+	return &spoolIndexBuilder{
+		dir:             dir,
+		sortBufferBytes: budget,
+		spoolPath:       f.Name(),
+		spool:           f,
+		spoolWriter:     bufio.NewWriter(f),
+	}, nil
+}
+
+func (b *spoolIndexBuilder) add(key string, offset int64) error {
+	if e := writeUint32(b.spoolWriter, len(key)); e != nil {
+		return e
+	}
+	if _, e := b.spoolWriter.WriteString(key); e != nil {
+		return e
+	}
+	return writeUint64(b.spoolWriter, offset)
+}
+
+// spoolTuple is one (key, offset) entry, either as spooled by add or
+// as stored in a sorted run.
+type spoolTuple struct {
+	key    string
+	offset int64
+}
+
+// sortRuns drains the spool, sorting it in SortBufferBytes-sized
+// chunks and writing each chunk out, already sorted by key, as its own
+// run file. It is idempotent: later calls do nothing.
+func (b *spoolIndexBuilder) sortRuns() error {
+	if b.sorted {
+		return nil
+	}
+	b.sorted = true
+
+	if e := b.spoolWriter.Flush(); e != nil {
+		return fmt.Errorf("Failed to flush index spool: %v", e)
+	}
+	if _, e := b.spool.Seek(0, io.SeekStart); e != nil {
+		return fmt.Errorf("Failed to rewind index spool: %v", e)
+	}
+
+	r := bufio.NewReader(b.spool)
+	var chunk []spoolTuple
+	var chunkBytes int64
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		// Stable: tuples of the same key must keep spool order,
+		// which is Put order, i.e. ascending offset.
+		sort.SliceStable(chunk, func(i, j int) bool { return chunk[i].key < chunk[j].key })
+
+		run, e := os.CreateTemp(b.dir, "sstable-run-*")
+		if e != nil {
+			return e
+		}
+		defer run.Close()
+
+		w := bufio.NewWriter(run)
+		for _, t := range chunk {
+			if e := writeUint32(w, len(t.key)); e != nil {
+				return e
+			}
+			if _, e := w.WriteString(t.key); e != nil {
+				return e
+			}
+			if e := writeUint64(w, t.offset); e != nil {
+				return e
+			}
+		}
+		if e := w.Flush(); e != nil {
+			return e
+		}
+
+		b.runPaths = append(b.runPaths, run.Name())
+		chunk = nil
+		chunkBytes = 0
+		return nil
+	}
+
+	for {
+		keySize, e := readUint32(r)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return fmt.Errorf("Failed to read index spool: %v", e)
+		}
+		keyBytes := make([]byte, keySize)
+		if _, e := io.ReadFull(r, keyBytes); e != nil {
+			return fmt.Errorf("Failed to read index spool: %v", e)
+		}
+		offset, e := readUint64(r)
+		if e != nil {
+			return fmt.Errorf("Failed to read index spool: %v", e)
+		}
+
+		chunk = append(chunk, spoolTuple{key: string(keyBytes), offset: int64(offset)})
+		chunkBytes += int64(4+len(keyBytes)) + 8
+		if chunkBytes >= b.sortBufferBytes {
+			if e := flush(); e != nil {
+				return fmt.Errorf("Failed to write sorted run: %v", e)
+			}
+		}
+	}
+	if e := flush(); e != nil {
+		return fmt.Errorf("Failed to write sorted run: %v", e)
+	}
+
+	b.spool.Close()
+	os.Remove(b.spoolPath)
+	return nil
+}
+
+func (b *spoolIndexBuilder) writeBlock(w io.Writer) error {
+	if e := b.sortRuns(); e != nil {
+		return e
+	}
+
+	// A key's offsets and count must be preceded, in the file, by the
+	// total number of unique keys, which is only known once every run
+	// has been merged. So merge once just to count groups, then merge
+	// again, from fresh file handles, to actually stream them out.
+	count, e := b.countGroups()
+	if e != nil {
+		return fmt.Errorf("Failed to count index groups: %v", e)
+	}
+
+	if e := writeUint32(w, int(separator)); e != nil {
+		return fmt.Errorf("Failed to write the separator: %v", e)
+	}
+	if e := writeUint32(w, count); e != nil {
+		return fmt.Errorf("Failed to write the number of unique keys: %v", e)
+	}
+
+	it, e := b.merge()
+	if e != nil {
+		return fmt.Errorf("Failed to merge sorted runs: %v", e)
+	}
+	defer it.close()
+
+	for {
+		key, offsets, ok, e := it.next()
+		if e != nil {
+			return fmt.Errorf("Failed to merge sorted runs: %v", e)
+		}
+		if !ok {
+			break
+		}
+		if e := writeIndexEntry(w, key, offsets); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (b *spoolIndexBuilder) countGroups() (int, error) {
+	it, e := b.merge()
+	if e != nil {
+		return 0, e
+	}
+	defer it.close()
+
+	n := 0
+	for {
+		_, _, ok, e := it.next()
+		if e != nil {
+			return 0, e
+		}
+		if !ok {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+// groups sorts the spool into runs, if it hasn't already, and returns
+// an iterator that k-way merges them into ascending-key groups.
+func (b *spoolIndexBuilder) groups() (groupSource, error) {
+	if e := b.sortRuns(); e != nil {
+		return nil, e
+	}
+	return b.merge()
+}
+
+func (b *spoolIndexBuilder) close() error {
+	for _, p := range b.runPaths {
+		os.Remove(p)
+	}
+	if b.spool != nil {
+		b.spool.Close()
+		os.Remove(b.spoolPath)
+	}
+	return nil
+}
+
+// merge opens fresh handles onto every sorted run and returns an
+// iterator that k-way merges them into ascending-key groups, each
+// holding every offset written for that key, in the order Put wrote
+// them.
+func (b *spoolIndexBuilder) merge() (*runMergeIterator, error) {
+	it := &runMergeIterator{}
+	for i, p := range b.runPaths {
+		f, e := os.Open(p)
+		if e != nil {
+			it.close()
+			return nil, e
+		}
+		it.files = append(it.files, f)
+		it.readers = append(it.readers, bufio.NewReader(f))
+		if e := it.pull(i); e != nil && e != io.EOF {
+			it.close()
+			return nil, e
+		}
+	}
+	heap.Init(&it.h)
+	return it, nil
+}
+
+// runHeapEntry is one not-yet-merged tuple, tagged with which run file
+// it came from so ties on equal keys break in run order, preserving
+// the original Put (ascending offset) order across runs.
+type runHeapEntry struct {
+	key    string
+	offset int64
+	run    int
+}
+
+type runHeap []runHeapEntry
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].run < h[j].run
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(runHeapEntry)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
+
+type runMergeIterator struct {
+	files   []*os.File
+	readers []*bufio.Reader
+	h       runHeap
+}
+
+// pull reads the next tuple from run and pushes it onto the heap. It
+// returns io.EOF, without error, once that run is exhausted.
+func (it *runMergeIterator) pull(run int) error {
+	keySize, e := readUint32(it.readers[run])
+	if e == io.EOF {
+		return io.EOF
+	}
+	if e != nil {
+		return e
+	}
+	keyBytes := make([]byte, keySize)
+	if _, e := io.ReadFull(it.readers[run], keyBytes); e != nil {
+		return e
+	}
+	offset, e := readUint64(it.readers[run])
+	if e != nil {
+		return e
+	}
+	heap.Push(&it.h, runHeapEntry{key: string(keyBytes), offset: int64(offset), run: run})
+	return nil
+}
+
+// next returns the next group: a key and every offset recorded for
+// it, across all runs, in ascending key order.
+func (it *runMergeIterator) next() (key string, offsets []int64, ok bool, e error) {
+	if it.h.Len() == 0 {
+		return "", nil, false, nil
+	}
+
+	key = it.h[0].key
+	for it.h.Len() > 0 && it.h[0].key == key {
+		top := heap.Pop(&it.h).(runHeapEntry)
+		offsets = append(offsets, top.offset)
+		if e := it.pull(top.run); e != nil && e != io.EOF {
+			return "", nil, false, e
+		}
+	}
+	return key, offsets, true, nil
+}
+
+func (it *runMergeIterator) close() {
+	for _, f := range it.files {
+		f.Close()
+	}
+}
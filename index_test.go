@@ -0,0 +1,90 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// buildSpooledRecords is buildRecords' counterpart for
+// NewWriterWithOptions: it writes records through a spool-backed
+// index builder instead of an in-memory map.
+func buildSpooledRecords(w io.Writer, opts WriterOptions) *Writer {
+	ww, e := NewWriterWithOptions(w, opts)
+	must(e)
+	for _, kv := range records {
+		must(ww.Put(kv[0], kv[1]))
+	}
+	return ww
+}
+
+func TestSpoolIndexBuilderRoundtrip(t *testing.T) {
+	// A tiny SortBufferBytes forces every tuple into its own sorted
+	// run, exercising the k-way merge even for this handful of keys.
+	cases := []struct {
+		name string
+		opts WriterOptions
+	}{
+		{name: "default buffer", opts: WriterOptions{}},
+		{name: "one tuple per run", opts: WriterOptions{SortBufferBytes: 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ww := buildSpooledRecords(&buf, c.opts)
+			must(ww.WriteIndex())
+
+			r, e := Open(bytes.NewReader(buf.Bytes()))
+			if e != nil {
+				t.Fatalf("Open failed: %v", e)
+			}
+
+			all, e := r.GetAll("apple")
+			if e != nil || !reflect.DeepEqual(all, []string{"pie", "imac"}) {
+				t.Errorf("GetAll(apple) = %v, %v; want [pie imac], nil", all, e)
+			}
+
+			it := r.Iterator()
+			var got [][2]string
+			for it.Next() {
+				got = append(got, [2]string{it.Key(), it.Value()})
+			}
+			if e := it.Err(); e != nil {
+				t.Fatalf("Iterator error: %v", e)
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("Iterator yielded %v; want %v", got, records)
+			}
+		})
+	}
+}
+
+// BenchmarkWriterMemIndex and BenchmarkWriterSpoolIndex compare the
+// memory footprint of Create's in-memory index against
+// NewWriterWithOptions' spooled one. Run with, e.g.,
+// `go test -run NONE -bench Writer -benchtime 10000000x` to compare
+// them at 10M keys.
+func BenchmarkWriterMemIndex(b *testing.B) {
+	w, e := Create(io.Discard)
+	must(e)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		must(w.Put(fmt.Sprintf("key-%d", i), "value"))
+	}
+}
+
+func BenchmarkWriterSpoolIndex(b *testing.B) {
+	w, e := NewWriterWithOptions(io.Discard, WriterOptions{})
+	must(e)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		must(w.Put(fmt.Sprintf("key-%d", i), "value"))
+	}
+	b.StopTimer()
+	must(w.idx.close())
+}
@@ -0,0 +1,24 @@
+// Package block groups consecutive SSTable records into independently
+// compressed blocks, and reads them back given the entries a Writer
+// produced.
+package block
+
+import "hash/crc32"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Entry describes one compressed block: where it starts in the file,
+// its compressed and uncompressed sizes, the CRC32 of its compressed
+// bytes, and the first key it holds, which callers use to binary
+// search the block index for the block that may hold a given key.
+// Entries assume records are added to a Writer in non-decreasing key
+// order, the same precondition sstable.Merge's output satisfies and
+// Writer.Add enforces; Iterator-style full-file scans remain correct
+// regardless of order.
+type Entry struct {
+	FirstKey        string
+	Offset          int64
+	CompressedLen   uint32
+	UncompressedLen uint32
+	CRC32           uint32
+}
@@ -0,0 +1,67 @@
+package block
+
+import "container/list"
+
+// Cache is a byte-budgeted LRU cache of decompressed blocks, keyed by
+// their Entry.Offset, shared by a Reader's Get and Iterator so
+// a hot block is decompressed at most once.
+type Cache struct {
+	budget int
+	used   int
+	ll     *list.List
+	items  map[int64]*list.Element
+}
+
+type cacheItem struct {
+	offset int64
+	data   []byte
+}
+
+// NewCache returns a Cache that evicts its least recently used block
+// once the total size of cached, decompressed blocks would exceed
+// budget bytes. A zero or negative budget disables caching: Get always
+// misses and Put is a no-op.
+func NewCache(budget int) *Cache {
+	return &Cache{budget: budget, ll: list.New(), items: make(map[int64]*list.Element)}
+}
+
+// Get returns the cached, decompressed bytes of the block at offset,
+// if present.
+func (c *Cache) Get(offset int64) ([]byte, bool) {
+	e, ok := c.items[offset]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheItem).data, true
+}
+
+// Put caches data as the decompressed block at offset, evicting the
+// least recently used blocks first if needed to stay within budget.
+func (c *Cache) Put(offset int64, data []byte) {
+	if c.budget <= 0 || len(data) > c.budget {
+		return
+	}
+
+	if e, ok := c.items[offset]; ok {
+		c.used -= len(e.Value.(*cacheItem).data)
+		c.ll.MoveToFront(e)
+		e.Value.(*cacheItem).data = data
+		c.used += len(data)
+	} else {
+		e := c.ll.PushFront(&cacheItem{offset: offset, data: data})
+		c.items[offset] = e
+		c.used += len(data)
+	}
+
+	for c.used > c.budget {
+		back := c.ll.Back()
+		if back == nil {
+			break
+		}
+		item := back.Value.(*cacheItem)
+		c.used -= len(item.data)
+		delete(c.items, item.offset)
+		c.ll.Remove(back)
+	}
+}
@@ -0,0 +1,42 @@
+package block
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/wangkuiyi/sstable/internal/codec"
+)
+
+// ErrCorrupt reports that a block's CRC32 checksum does not match its
+// compressed bytes. Callers can errors.As this to recognize corruption
+// as distinct from the I/O errors ReadAt also returns.
+type ErrCorrupt struct {
+	Offset int64
+}
+
+func (e *ErrCorrupt) Error() string {
+	return fmt.Sprintf("block: corrupt block at offset %d", e.Offset)
+}
+
+// ReadAt seeks r to the block described by e, reads and verifies its
+// compressed bytes, and decompresses them with c.
+func ReadAt(r io.ReadSeeker, c codec.Codec, e Entry) ([]byte, error) {
+	if _, err := r.Seek(e.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("block: failed to seek to block at %d: %v", e.Offset, err)
+	}
+
+	compressed := make([]byte, e.CompressedLen)
+	if _, err := io.ReadFull(r, compressed); err != nil {
+		return nil, fmt.Errorf("block: failed to read block at %d: %v", e.Offset, err)
+	}
+	if crc32.Checksum(compressed, crcTable) != e.CRC32 {
+		return nil, &ErrCorrupt{Offset: e.Offset}
+	}
+
+	raw, err := c.Decode(make([]byte, 0, e.UncompressedLen), compressed)
+	if err != nil {
+		return nil, fmt.Errorf("block: failed to decompress block at %d: %v", e.Offset, err)
+	}
+	return raw, nil
+}
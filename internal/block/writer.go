@@ -0,0 +1,102 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/wangkuiyi/sstable/internal/codec"
+)
+
+// Writer accumulates raw record bytes and flushes them as compressed
+// blocks of approximately size bytes each, writing the compressed
+// bytes to w as they are produced.
+type Writer struct {
+	w        io.Writer
+	codec    codec.Codec
+	size     int
+	offset   int64
+	buf      bytes.Buffer
+	firstKey string
+	entries  []Entry
+
+	// hasLastKey and lastKey track the most recently added key across
+	// block boundaries, so Add can reject an out-of-order key: Reader
+	// binary-searches block Entries by FirstKey, which only finds the
+	// right block when keys arrive in non-decreasing order.
+	hasLastKey bool
+	lastKey    string
+}
+
+// NewWriter returns a Writer that writes compressed blocks of
+// approximately size uncompressed bytes each to w, compressing every
+// block with c, and numbering block offsets from startOffset (the
+// position w is already at).
+func NewWriter(w io.Writer, c codec.Codec, size int, startOffset int64) *Writer {
+	return &Writer{w: w, codec: c, size: size, offset: startOffset}
+}
+
+// Add appends one record's raw bytes (e.g. key-size/key/value-size/
+// value, without a per-record checksum, since the whole block's CRC32
+// covers it) to the block currently being filled, first flushing that
+// block if it already holds size bytes or more. key is the record's
+// key, used to track the block's first key for the index entry Flush
+// produces, and to reject keys added out of order: Reader finds a
+// block by binary-searching Entries' FirstKey, which only finds the
+// right block when keys arrive in non-decreasing order.
+func (bw *Writer) Add(key string, record []byte) error {
+	if bw.hasLastKey && key < bw.lastKey {
+		return fmt.Errorf("block: key %q written after %q; block-compressed layout requires non-decreasing key order", key, bw.lastKey)
+	}
+	bw.lastKey, bw.hasLastKey = key, true
+
+	if bw.buf.Len() >= bw.size {
+		if e := bw.Flush(); e != nil {
+			return e
+		}
+	}
+	if bw.buf.Len() == 0 {
+		bw.firstKey = key
+	}
+	bw.buf.Write(record)
+	return nil
+}
+
+// Flush compresses and writes out any buffered records as one block,
+// even if it is smaller than size, appending its Entry. It is a no-op
+// if nothing is buffered.
+func (bw *Writer) Flush() error {
+	if bw.buf.Len() == 0 {
+		return nil
+	}
+
+	raw := bw.buf.Bytes()
+	compressed, e := bw.codec.Encode(nil, raw)
+	if e != nil {
+		return fmt.Errorf("block: failed to compress block: %v", e)
+	}
+
+	if _, e := bw.w.Write(compressed); e != nil {
+		return fmt.Errorf("block: failed to write block: %v", e)
+	}
+
+	bw.entries = append(bw.entries, Entry{
+		FirstKey:        bw.firstKey,
+		Offset:          bw.offset,
+		CompressedLen:   uint32(len(compressed)),
+		UncompressedLen: uint32(len(raw)),
+		CRC32:           crc32.Checksum(compressed, crcTable),
+	})
+
+	bw.offset += int64(len(compressed))
+	bw.buf.Reset()
+	return nil
+}
+
+// Entries returns every block written so far. Call Flush first to
+// finalize any buffered, not-yet-written block.
+func (bw *Writer) Entries() []Entry { return bw.entries }
+
+// Offset returns the file offset the next block will be written at.
+func (bw *Writer) Offset() int64 { return bw.offset }
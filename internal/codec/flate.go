@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+const flateName = "flate"
+
+// Flate compresses blocks with DEFLATE (compress/flate), the one
+// general-purpose compressor the Go standard library ships, so
+// picking it needs no extra dependency.
+type Flate struct{}
+
+func (Flate) Name() string { return flateName }
+
+func (Flate) Encode(dst, src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, e := flate.NewWriter(&buf, flate.DefaultCompression)
+	if e != nil {
+		return nil, fmt.Errorf("codec: failed to create flate writer: %v", e)
+	}
+	if _, e := w.Write(src); e != nil {
+		return nil, fmt.Errorf("codec: failed to compress block: %v", e)
+	}
+	if e := w.Close(); e != nil {
+		return nil, fmt.Errorf("codec: failed to flush compressed block: %v", e)
+	}
+	return append(dst, buf.Bytes()...), nil
+}
+
+func (Flate) Decode(dst, src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	decompressed, e := io.ReadAll(r)
+	if e != nil {
+		return nil, fmt.Errorf("codec: failed to decompress block: %v", e)
+	}
+	return append(dst, decompressed...), nil
+}
@@ -0,0 +1,18 @@
+package codec
+
+const noneName = "none"
+
+// None is the identity Codec: Encode and Decode copy src to dst
+// unchanged. It is the default when WriterOptions.Codec is nil, for
+// callers who want block grouping without paying a compression cost.
+type None struct{}
+
+func (None) Name() string { return noneName }
+
+func (None) Encode(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (None) Decode(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
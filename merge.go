@@ -0,0 +1,202 @@
+package sstable
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// MergePolicy controls how Merge resolves a key written more than
+// once, whether within a single source or across several of them.
+type MergePolicy int
+
+const (
+	// KeepLatest keeps only the value of the most recent write of a
+	// key, treating srcs as ordered oldest to newest and, within a
+	// source, Put calls as ordered oldest to newest. This is Merge's
+	// default and matches what Get already returns for a single file.
+	KeepLatest MergePolicy = iota
+	// KeepFirst keeps only the value of the oldest write of a key.
+	KeepFirst
+	// KeepAll keeps every write of a key, oldest to newest, as separate
+	// records in the output.
+	KeepAll
+)
+
+// mergeEvent is one write of a key: either a value, or a tombstone
+// left by Writer.Delete.
+type mergeEvent struct {
+	value     string
+	tombstone bool
+}
+
+// sortedKeyEvents is one key's writes within a single source, in the
+// order Put (or Delete) recorded them.
+type sortedKeyEvents struct {
+	key    string
+	events []mergeEvent
+}
+
+// sortedEntries returns every key ss knows about, sorted, together
+// with its writes in the order they happened. It is the ordered
+// stream Merge consumes from each source; Reader.Iterator is left
+// returning file order, since that is its own documented and tested
+// contract and callers other than Merge rely on it.
+func (ss *Reader) sortedEntries() ([]sortedKeyEvents, error) {
+	if ss.hasCompactIndex {
+		return nil, fmt.Errorf("Cannot enumerate keys of a file with only a compact index")
+	}
+	if ss.hasBlockIndex {
+		return nil, fmt.Errorf("Cannot enumerate keys of a file with only a block index")
+	}
+
+	keys := make([]string, 0, len(ss.index))
+	for k := range ss.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	entries := make([]sortedKeyEvents, len(keys))
+	for i, key := range keys {
+		offsets := ss.index[key]
+		events := make([]mergeEvent, len(offsets))
+		for j, o := range offsets {
+			_, value, _, deleted, e := ss.readRecordAt(o)
+			if e != nil {
+				return nil, fmt.Errorf("Failed to read write of key %s: %v", key, e)
+			}
+			events[j] = mergeEvent{value: value, tombstone: deleted}
+		}
+		entries[i] = sortedKeyEvents{key: key, events: events}
+	}
+	return entries, nil
+}
+
+// mergeHeapEntry is one source's next not-yet-merged key, the unit
+// Merge's min-heap orders by (key, source index).
+type mergeHeapEntry struct {
+	key         string
+	sourceIndex int
+	events      []mergeEvent
+}
+
+type mergeHeap []mergeHeapEntry
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].sourceIndex < h[j].sourceIndex
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(mergeHeapEntry))
+}
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
+
+// Merge is MergeWithPolicy with KeepLatest: the newest write of a key,
+// across every source, wins.
+func Merge(dst io.Writer, srcs ...*Reader) error {
+	return MergeWithPolicy(dst, KeepLatest, srcs...)
+}
+
+// MergeWithPolicy performs a k-way merge of srcs, oldest first, and
+// writes the result to dst via a fresh Writer, finalized with
+// WriteIndex. Keys are merged in sorted order; a key written in more
+// than one source, or more than once within a source, is resolved by
+// policy. A key whose most recent write (across every source) is a
+// Writer.Delete tombstone is dropped from the output regardless of
+// policy.
+func MergeWithPolicy(dst io.Writer, policy MergePolicy, srcs ...*Reader) error {
+	sources := make([][]sortedKeyEvents, len(srcs))
+	positions := make([]int, len(srcs))
+
+	h := &mergeHeap{}
+	heap.Init(h)
+	for i, src := range srcs {
+		entries, e := src.sortedEntries()
+		if e != nil {
+			return fmt.Errorf("Failed to enumerate source %d: %v", i, e)
+		}
+		sources[i] = entries
+		if len(entries) > 0 {
+			heap.Push(h, mergeHeapEntry{key: entries[0].key, sourceIndex: i, events: entries[0].events})
+			positions[i] = 1
+		}
+	}
+
+	w, e := Create(dst)
+	if e != nil {
+		return fmt.Errorf("Failed to create merged SSTable: %v", e)
+	}
+
+	for h.Len() > 0 {
+		key := (*h)[0].key
+
+		var group []mergeHeapEntry
+		for h.Len() > 0 && (*h)[0].key == key {
+			entry := heap.Pop(h).(mergeHeapEntry)
+			group = append(group, entry)
+
+			i := entry.sourceIndex
+			if positions[i] < len(sources[i]) {
+				next := sources[i][positions[i]]
+				positions[i]++
+				heap.Push(h, mergeHeapEntry{key: next.key, sourceIndex: i, events: next.events})
+			}
+		}
+
+		values, keep := resolveMergeEvents(policy, group)
+		if !keep {
+			continue
+		}
+		for _, v := range values {
+			if e := w.Put(key, v); e != nil {
+				return fmt.Errorf("Failed to write merged key %s: %v", key, e)
+			}
+		}
+	}
+
+	return w.WriteIndex()
+}
+
+// resolveMergeEvents flattens group's events, already ordered oldest
+// to newest (group arrives sorted by source index, and each source's
+// own events are already ordered by write time), and decides what
+// Merge should write for their shared key under policy.
+func resolveMergeEvents(policy MergePolicy, group []mergeHeapEntry) (values []string, keep bool) {
+	var events []mergeEvent
+	for _, g := range group {
+		events = append(events, g.events...)
+	}
+	if len(events) == 0 || events[len(events)-1].tombstone {
+		return nil, false
+	}
+
+	switch policy {
+	case KeepFirst:
+		for _, e := range events {
+			if !e.tombstone {
+				return []string{e.value}, true
+			}
+		}
+		return nil, false
+	case KeepAll:
+		for _, e := range events {
+			if !e.tombstone {
+				values = append(values, e.value)
+			}
+		}
+		return values, true
+	default: // KeepLatest
+		return []string{events[len(events)-1].value}, true
+	}
+}
@@ -0,0 +1,153 @@
+package sstable
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// buildMergeSource writes kvs (and, for any key listed in deletes,
+// a trailing Writer.Delete) into a fresh v2 SSTable and returns a
+// Reader over it.
+func buildMergeSource(t *testing.T, kvs [][2]string, deletes []string) *Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, e := Create(&buf)
+	must(e)
+	for _, kv := range kvs {
+		must(w.Put(kv[0], kv[1]))
+	}
+	for _, k := range deletes {
+		must(w.Delete(k))
+	}
+	must(w.WriteIndex())
+
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	return r
+}
+
+// readAll opens bs as an SSTable and returns every (key, value) record
+// in file order.
+func readAll(t *testing.T, bs []byte) [][2]string {
+	t.Helper()
+	r, e := Open(bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+	var got [][2]string
+	it := r.Iterator()
+	for it.Next() {
+		got = append(got, [2]string{it.Key(), it.Value()})
+	}
+	if e := it.Err(); e != nil {
+		t.Fatalf("Iterator error: %v", e)
+	}
+	return got
+}
+
+func TestMergeOverlappingRanges(t *testing.T) {
+	// src0 is the older file, src1 the newer one; "banana" only exists
+	// in src0, "cherry" only in src1, and "apple" overlaps, with src1's
+	// value expected to win under KeepLatest.
+	src0 := buildMergeSource(t, [][2]string{{"apple", "old-pie"}, {"banana", "split"}}, nil)
+	src1 := buildMergeSource(t, [][2]string{{"apple", "new-pie"}, {"cherry", "cake"}}, nil)
+
+	var out bytes.Buffer
+	if e := Merge(&out, src0, src1); e != nil {
+		t.Fatalf("Merge failed: %v", e)
+	}
+
+	want := [][2]string{{"apple", "new-pie"}, {"banana", "split"}, {"cherry", "cake"}}
+	if got := readAll(t, out.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge yielded %v; want %v", got, want)
+	}
+}
+
+func TestMergeDuplicateKeyWithinOneSource(t *testing.T) {
+	src := buildMergeSource(t, [][2]string{{"apple", "pie"}, {"apple", "imac"}}, nil)
+
+	var out bytes.Buffer
+	if e := Merge(&out, src); e != nil {
+		t.Fatalf("Merge failed: %v", e)
+	}
+	if got, want := readAll(t, out.Bytes()), [][2]string{{"apple", "imac"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KeepLatest Merge yielded %v; want %v", got, want)
+	}
+
+	out.Reset()
+	if e := MergeWithPolicy(&out, KeepFirst, src); e != nil {
+		t.Fatalf("MergeWithPolicy(KeepFirst) failed: %v", e)
+	}
+	if got, want := readAll(t, out.Bytes()), [][2]string{{"apple", "pie"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KeepFirst Merge yielded %v; want %v", got, want)
+	}
+
+	out.Reset()
+	if e := MergeWithPolicy(&out, KeepAll, src); e != nil {
+		t.Fatalf("MergeWithPolicy(KeepAll) failed: %v", e)
+	}
+	if got, want := readAll(t, out.Bytes()), [][2]string{{"apple", "pie"}, {"apple", "imac"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KeepAll Merge yielded %v; want %v", got, want)
+	}
+}
+
+func TestMergeTombstonePropagation(t *testing.T) {
+	// src0, the older file, wrote "apple"; src1, the newer one, deletes
+	// it. The merged output must drop "apple" entirely.
+	src0 := buildMergeSource(t, [][2]string{{"apple", "pie"}, {"banana", "split"}}, nil)
+	src1 := buildMergeSource(t, nil, []string{"apple"})
+
+	var out bytes.Buffer
+	if e := Merge(&out, src0, src1); e != nil {
+		t.Fatalf("Merge failed: %v", e)
+	}
+	if got, want := readAll(t, out.Bytes()), [][2]string{{"banana", "split"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge yielded %v; want %v", got, want)
+	}
+
+	// A later re-write of "apple" after the delete, in a still-newer
+	// source, resurrects it.
+	src2 := buildMergeSource(t, [][2]string{{"apple", "reborn"}}, nil)
+	out.Reset()
+	if e := Merge(&out, src0, src1, src2); e != nil {
+		t.Fatalf("Merge failed: %v", e)
+	}
+	want := [][2]string{{"apple", "reborn"}, {"banana", "split"}}
+	if got := readAll(t, out.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge yielded %v; want %v", got, want)
+	}
+}
+
+func TestMergeRejectsCompactIndexedSource(t *testing.T) {
+	var buf bytes.Buffer
+	ww, _ := buildRecords(&buf)
+	must(ww.WriteCompactIndex())
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+
+	var out bytes.Buffer
+	if e := Merge(&out, r); e == nil {
+		t.Errorf("Merge did not reject a compact-indexed source")
+	}
+}
+
+func TestMergeRejectsBlockIndexedSource(t *testing.T) {
+	var buf bytes.Buffer
+	ww := buildBlockRecords(&buf, WriterOptions{BlockSize: defaultBlockSize})
+	must(ww.WriteBlockIndex())
+	r, e := Open(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+
+	var out bytes.Buffer
+	if e := Merge(&out, r); e == nil {
+		t.Errorf("Merge did not reject a block-indexed source")
+	}
+}
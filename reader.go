@@ -1,30 +1,138 @@
 package sstable
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
+	"sort"
+
+	"github.com/wangkuiyi/sstable/internal/block"
+	"github.com/wangkuiyi/sstable/internal/codec"
 )
 
+// ErrCorrupt reports that a CRC32 checksum stored in a v2 SSTable file
+// does not match the bytes it protects.
+type ErrCorrupt struct {
+	What string
+}
+
+func (e *ErrCorrupt) Error() string {
+	return fmt.Sprintf("sstable: corrupt %s", e.What)
+}
+
 // Reader loads the index of an SSTable file into the memory.  If the
 // file doesn't have an index, it scans the file and builds the index.
 // After that, we can query key and values of a key.
 type Reader struct {
 	io.ReadSeeker
-	index map[string][]int64
+	index      map[string][]int64
+	recordsEnd int64 // offset just past the last record, where the index (if any) begins.
+	legacyV1   bool  // true if the file has no v2 magic/version header or CRCs.
+
+	// hasCompactIndex, compactIndexStart and compactBucketCount are set
+	// by loadIndex when the file carries a Writer.WriteCompactIndex
+	// footer instead of (or as well as scanning for) a linear one. When
+	// true, Get answers through LookupCompact instead of the index map,
+	// which is left empty, and GetAll returns an error.
+	hasCompactIndex    bool
+	compactIndexStart  int64
+	compactBucketCount uint32
+
+	// hasBlockIndex, blockEntries, blockCodec and blockCache are set by
+	// loadIndex when the file carries a Writer.WriteBlockIndex footer.
+	// When true, Get and Iterator answer by locating, fetching and
+	// decompressing individual blocks instead of using the index map,
+	// which is left empty, and GetAll returns an error.
+	hasBlockIndex bool
+	blockEntries  []block.Entry
+	blockCodec    codec.Codec
+	blockCache    *block.Cache
+}
+
+// ReaderOptions configures Open.
+type ReaderOptions struct {
+	// LegacyV1 forces Open to treat the file as the original,
+	// header-less, checksum-less format, bypassing magic-number
+	// auto-detection. Most callers should leave this false: Open
+	// already detects and reads LegacyV1 files on its own.
+	LegacyV1 bool
+
+	// BlockCacheBytes bounds how many decompressed block-compressed
+	// layout blocks (see WriterOptions.BlockSize) this Reader keeps
+	// around at once. Zero or negative disables caching: every lookup
+	// or iteration step that needs a block decompresses it again.
+	BlockCacheBytes int
 }
 
 func Open(r io.ReadSeeker) (*Reader, error) {
+	return OpenWithOptions(r, ReaderOptions{})
+}
+
+// OpenWithOptions is like Open but lets the caller force LegacyV1
+// handling instead of relying on magic-number auto-detection.
+func OpenWithOptions(r io.ReadSeeker, opts ReaderOptions) (*Reader, error) {
 	ss := &Reader{
 		ReadSeeker: r,
-		index:      make(map[string][]int64)}
+		index:      make(map[string][]int64),
+		legacyV1:   opts.LegacyV1,
+		blockCache: block.NewCache(opts.BlockCacheBytes),
+	}
+
+	if !opts.LegacyV1 {
+		v2, e := ss.hasHeader()
+		if e != nil {
+			return nil, e
+		}
+		ss.legacyV1 = !v2
+	}
+
 	if e := ss.loadIndex(); e != nil {
 		return nil, e
 	}
 	return ss, nil
 }
 
+// hasHeader reports whether the file starts with the v2 magic number
+// and a supported version byte.
+func (ss *Reader) hasHeader() (bool, error) {
+	if _, e := ss.Seek(0, io.SeekStart); e != nil {
+		return false, fmt.Errorf("Failed to seek to the start of the file: %v", e)
+	}
+
+	magic, e := readUint32(ss)
+	if e == io.EOF {
+		return false, nil
+	}
+	if e != nil {
+		return false, fmt.Errorf("Failed to read file header: %v", e)
+	}
+	if magic != magicNumber {
+		return false, nil
+	}
+
+	var version [1]byte
+	if _, e := io.ReadFull(ss, version[:]); e != nil {
+		return false, fmt.Errorf("Failed to read file format version: %v", e)
+	}
+	if version[0] != formatVersion {
+		return false, &ErrCorrupt{What: fmt.Sprintf("file header: unsupported format version %d", version[0])}
+	}
+	return true, nil
+}
+
+// recordsStart returns the offset of the first record, which is past
+// the v2 header when present.
+func (ss *Reader) recordsStart() int64 {
+	if ss.legacyV1 {
+		return 0
+	}
+	return headerSize
+}
+
 func must(e error) {
 	if e != nil {
 		log.Fatalf("Fatal due to %v", e)
@@ -54,12 +162,666 @@ func (ss *Reader) hasIndex() (uint64, error) {
 	return offset, nil
 }
 
+// loadIndex populates ss.index, either by decoding the index block
+// appended to the file, or, if the file has no index, by scanning all
+// records from the beginning and rebuilding the index on the fly. A
+// block index footer, if present, takes priority over everything else,
+// followed by a compact index footer: each ends the file in its own,
+// differently-sized footer, so they are checked first, in that order,
+// before falling back to the linear index and then a full scan.
 func (ss *Reader) loadIndex() error {
+	entriesStart, codecID, crc, e := ss.hasBlockIndexFooter()
+	if e == nil {
+		return ss.loadBlockIndex(entriesStart, codecID, crc)
+	}
+
+	headerStart, bucketCount, crc, e := ss.hasCompactIndexFooter()
+	if e == nil {
+		return ss.loadCompactIndex(headerStart, bucketCount, crc)
+	}
+
 	offset, e := ss.hasIndex()
-	fmt.Printf("Offset is %v", offset)
-	return e
+	if e == nil {
+		return ss.loadIndexAt(int64(offset))
+	}
+	return ss.scanRecords()
+}
+
+// hasCompactIndexFooter reports whether the file ends with a
+// Writer.WriteCompactIndex footer, returning the offset of its bucket
+// header table, its bucket count and its CRC32 checksum. A non-nil
+// error means the file has no such footer, not that it is corrupt.
+func (ss *Reader) hasCompactIndexFooter() (headerStart int64, bucketCount uint32, crc uint32, e error) {
+	fileLength, e := ss.Seek(0, io.SeekEnd)
+	must(e)
+
+	if fileLength < compactIndexEndFlagSize {
+		return 0, 0, 0, fmt.Errorf("Too short to have a compact index")
+	}
+
+	if _, e = ss.Seek(-compactIndexEndFlagSize, io.SeekEnd); e != nil {
+		return 0, 0, 0, fmt.Errorf("Failed to seek compact index footer: %v", e)
+	}
+
+	magic, e := readUint32(ss)
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("Failed to read compact index footer: %v", e)
+	}
+	if magic != compactMagic {
+		return 0, 0, 0, fmt.Errorf("No compact index footer")
+	}
+
+	bucketCount, e = readUint32(ss)
+	must(e)
+	start, e := readUint64(ss)
+	must(e)
+	crc, e = readUint32(ss)
+	must(e)
+
+	return int64(start), bucketCount, crc, nil
 }
 
+// loadCompactIndex verifies the CRC32 of the bucket header table and
+// entries written by Writer.WriteCompactIndex, then records enough of
+// the footer for LookupCompact to serve lookups with two seeks into
+// the index plus one to read the record.
+func (ss *Reader) loadCompactIndex(headerStart int64, bucketCount uint32, wantCRC uint32) error {
+	fileLength, e := ss.Seek(0, io.SeekEnd)
+	must(e)
+	footerStart := fileLength - compactIndexEndFlagSize
+
+	if _, e := ss.Seek(headerStart, io.SeekStart); e != nil {
+		return fmt.Errorf("Failed to seek to compact index at %d: %v", headerStart, e)
+	}
+
+	crcHash := crc32.New(crcTable)
+	if _, e := io.CopyN(crcHash, ss, footerStart-headerStart); e != nil {
+		return fmt.Errorf("Failed to read compact index: %v", e)
+	}
+	if crcHash.Sum32() != wantCRC {
+		return &ErrCorrupt{What: "compact index"}
+	}
+
+	ss.hasCompactIndex = true
+	ss.compactIndexStart = headerStart
+	ss.compactBucketCount = bucketCount
+	ss.recordsEnd = headerStart
+	return nil
+}
+
+// LookupCompact answers Get for a file written with
+// Writer.WriteCompactIndex: it seeks to key's bucket header, computes
+// its minimal-perfect-hash slot, seeks to that entry, and verifies the
+// full key by reading the record the entry points at. It returns an
+// error if the file has no compact index.
+func (ss *Reader) LookupCompact(key string) (string, error) {
+	if !ss.hasCompactIndex {
+		return "", fmt.Errorf("This file has no compact index; call Writer.WriteCompactIndex when writing it")
+	}
+
+	bucket := bucketHash(key) % uint64(ss.compactBucketCount)
+	if _, e := ss.Seek(ss.compactIndexStart+int64(bucket)*compactBucketHeaderSize, io.SeekStart); e != nil {
+		return "", fmt.Errorf("Failed to seek bucket header for key %s: %v", key, e)
+	}
+
+	seed, e := readUint32(ss)
+	if e != nil {
+		return "", fmt.Errorf("Failed to read bucket header for key %s: %v", key, e)
+	}
+	entryOffset, e := readUint64(ss)
+	if e != nil {
+		return "", fmt.Errorf("Failed to read bucket header for key %s: %v", key, e)
+	}
+	numEntries, e := readUint32(ss)
+	if e != nil {
+		return "", fmt.Errorf("Failed to read bucket header for key %s: %v", key, e)
+	}
+	if numEntries == 0 {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+
+	slot := slotHash(seed, key) % uint64(numEntries)
+	if _, e := ss.Seek(int64(entryOffset)+int64(slot)*compactEntrySize, io.SeekStart); e != nil {
+		return "", fmt.Errorf("Failed to seek bucket entry for key %s: %v", key, e)
+	}
+
+	wantHash, e := readUint64(ss)
+	if e != nil {
+		return "", fmt.Errorf("Failed to read bucket entry for key %s: %v", key, e)
+	}
+	valueOffset, e := readUint64(ss)
+	if e != nil {
+		return "", fmt.Errorf("Failed to read bucket entry for key %s: %v", key, e)
+	}
+	if wantHash != bucketHash(key) {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+
+	gotKey, value, _, deleted, e := ss.readRecordAt(int64(valueOffset))
+	if e != nil {
+		return "", fmt.Errorf("Failed to read value of key %s: %w", key, e)
+	}
+	if gotKey != key || deleted {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+	return value, nil
+}
+
+// hasBlockIndexFooter reports whether the file ends with a
+// Writer.WriteBlockIndex footer, returning the offset its block-index
+// entries start at, the id of the codec its blocks were compressed
+// with, and its CRC32 checksum. A non-nil error means the file has no
+// such footer, not that it is corrupt.
+func (ss *Reader) hasBlockIndexFooter() (entriesStart int64, codecID byte, crc uint32, e error) {
+	fileLength, e := ss.Seek(0, io.SeekEnd)
+	must(e)
+
+	if fileLength < blockIndexEndFlagSize {
+		return 0, 0, 0, fmt.Errorf("Too short to have a block index")
+	}
+
+	if _, e = ss.Seek(-blockIndexEndFlagSize, io.SeekEnd); e != nil {
+		return 0, 0, 0, fmt.Errorf("Failed to seek block index footer: %v", e)
+	}
+
+	magic, e := readUint32(ss)
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("Failed to read block index footer: %v", e)
+	}
+	if magic != blockIndexMagic {
+		return 0, 0, 0, fmt.Errorf("No block index footer")
+	}
+
+	var id [1]byte
+	if _, e := io.ReadFull(ss, id[:]); e != nil {
+		return 0, 0, 0, fmt.Errorf("Failed to read block index codec id: %v", e)
+	}
+	start, e := readUint64(ss)
+	must(e)
+	crc, e = readUint32(ss)
+	must(e)
+
+	return int64(start), id[0], crc, nil
+}
+
+// loadBlockIndex verifies the CRC32 of the block-index entries written
+// by Writer.WriteBlockIndex, decodes them, and resolves the codec they
+// were compressed with, so Get and Iterator can locate and decompress
+// individual blocks.
+func (ss *Reader) loadBlockIndex(entriesStart int64, id byte, wantCRC uint32) error {
+	c, e := codecByID(id)
+	if e != nil {
+		return fmt.Errorf("Failed to resolve block codec: %v", e)
+	}
+
+	fileLength, e := ss.Seek(0, io.SeekEnd)
+	must(e)
+	footerStart := fileLength - blockIndexEndFlagSize
+
+	if _, e := ss.Seek(entriesStart, io.SeekStart); e != nil {
+		return fmt.Errorf("Failed to seek to block index at %d: %v", entriesStart, e)
+	}
+
+	var body bytes.Buffer
+	tr := io.TeeReader(io.LimitReader(ss, footerStart-entriesStart), &body)
+
+	var entries []block.Entry
+	for {
+		key, e := readKey(tr)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return fmt.Errorf("Failed to read block index entry: %v", e)
+		}
+		offset, e := readUint64(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read block index entry: %v", e)
+		}
+		compressedLen, e := readUint32(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read block index entry: %v", e)
+		}
+		uncompressedLen, e := readUint32(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read block index entry: %v", e)
+		}
+		blockCRC, e := readUint32(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read block index entry: %v", e)
+		}
+		entries = append(entries, block.Entry{
+			FirstKey:        key,
+			Offset:          int64(offset),
+			CompressedLen:   compressedLen,
+			UncompressedLen: uncompressedLen,
+			CRC32:           blockCRC,
+		})
+	}
+
+	if crc32.Checksum(body.Bytes(), crcTable) != wantCRC {
+		return &ErrCorrupt{What: "block index"}
+	}
+
+	ss.hasBlockIndex = true
+	ss.blockEntries = entries
+	ss.blockCodec = c
+	ss.recordsEnd = entriesStart
+	return nil
+}
+
+// blockRecord is one (key, value) record decoded from a decompressed
+// block, as produced by decodeBlockRecords.
+type blockRecord struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// decodeBlockRecords parses the records packed into a decompressed
+// block, in the same key-size/key/value-size/value layout
+// encodeRecordBody writes, but without per-record checksums: the
+// block's own CRC32, already verified by block.ReadAt, covers all of
+// them at once.
+func decodeBlockRecords(raw []byte) ([]blockRecord, error) {
+	r := bytes.NewReader(raw)
+	var out []blockRecord
+	for r.Len() > 0 {
+		key, e := readKey(r)
+		if e != nil {
+			return nil, fmt.Errorf("Failed to read block record key: %v", e)
+		}
+		valueSize, e := readUint32(r)
+		if e != nil {
+			return nil, fmt.Errorf("Failed to read block record value size: %v", e)
+		}
+		if valueSize == deletedValueMarker {
+			out = append(out, blockRecord{key: key, deleted: true})
+			continue
+		}
+		valueBytes := make([]byte, valueSize)
+		if _, e := io.ReadFull(r, valueBytes); e != nil {
+			return nil, fmt.Errorf("Failed to read block record value: %v", e)
+		}
+		out = append(out, blockRecord{key: key, value: string(valueBytes)})
+	}
+	return out, nil
+}
+
+// fetchBlock returns e's decompressed records, serving them from
+// ss.blockCache when already decompressed.
+func (ss *Reader) fetchBlock(e block.Entry) ([]blockRecord, error) {
+	raw, ok := ss.blockCache.Get(e.Offset)
+	if !ok {
+		var err error
+		raw, err = block.ReadAt(ss.ReadSeeker, ss.blockCodec, e)
+		if err != nil {
+			var bc *block.ErrCorrupt
+			if errors.As(err, &bc) {
+				return nil, &ErrCorrupt{What: fmt.Sprintf("block at offset %d", bc.Offset)}
+			}
+			return nil, fmt.Errorf("Failed to read block at %d: %v", e.Offset, err)
+		}
+		ss.blockCache.Put(e.Offset, raw)
+	}
+	return decodeBlockRecords(raw)
+}
+
+// getBlock answers Get for a file written with Writer.WriteBlockIndex:
+// it binary searches blockEntries for the last block whose first key
+// is at most key, fetches it, and scans it for key's latest,
+// non-tombstoned value. This assumes blockEntries' first keys are in
+// non-decreasing order, which holds only if records were Put (and
+// Delete'd) in non-decreasing key order -- e.g. because they came from
+// Merge -- the same precondition block.Entry documents; Iterator does
+// not depend on it and always sees every record.
+func (ss *Reader) getBlock(key string) (string, error) {
+	i := sort.Search(len(ss.blockEntries), func(i int) bool {
+		return ss.blockEntries[i].FirstKey > key
+	})
+	if i == 0 {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+
+	records, e := ss.fetchBlock(ss.blockEntries[i-1])
+	if e != nil {
+		return "", e
+	}
+
+	value, found := "", false
+	for _, rec := range records {
+		if rec.key != key {
+			continue
+		}
+		if rec.deleted {
+			found = false
+			continue
+		}
+		value, found = rec.value, true
+	}
+	if !found {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+	return value, nil
+}
+
+// loadIndexAt decodes the index block starting at offset, which is the
+// layout written by Writer.WriteIndex: a separator, the number of
+// unique keys, and then, for each key, the key followed by its list of
+// record offsets.
+func (ss *Reader) loadIndexAt(offset int64) error {
+	if _, e := ss.Seek(offset, io.SeekStart); e != nil {
+		return fmt.Errorf("Failed to seek to index at %d: %v", offset, e)
+	}
+
+	// Tee the index block through a buffer so its bytes can be
+	// checksummed once fully read, the same way readRecordAt does for
+	// records.
+	var body bytes.Buffer
+	tr := io.TeeReader(ss, &body)
+
+	sp, e := readUint32(tr)
+	if e != nil {
+		return fmt.Errorf("Failed to read index separator: %v", e)
+	}
+	if sp != separator {
+		return fmt.Errorf("Expecting separator at index start, got %x", sp)
+	}
+
+	n, e := readUint32(tr)
+	if e != nil {
+		return fmt.Errorf("Failed to read number of unique keys: %v", e)
+	}
+
+	for i := uint32(0); i < n; i++ {
+		key, e := readKey(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read key in index: %v", e)
+		}
+
+		cnt, e := readUint32(tr)
+		if e != nil {
+			return fmt.Errorf("Failed to read number of offsets of key %s: %v", key, e)
+		}
+
+		offsets := make([]int64, cnt)
+		for j := range offsets {
+			o, e := readUint64(tr)
+			if e != nil {
+				return fmt.Errorf("Failed to read offset of key %s: %v", key, e)
+			}
+			offsets[j] = int64(o)
+		}
+
+		ss.index[key] = offsets
+	}
+
+	if !ss.legacyV1 {
+		wantCRC, e := readUint32(ss)
+		if e != nil {
+			return fmt.Errorf("Failed to read index checksum: %v", e)
+		}
+		if crc32.Checksum(body.Bytes(), crcTable) != wantCRC {
+			return &ErrCorrupt{What: "index block"}
+		}
+	}
+
+	ss.recordsEnd = offset
+	return nil
+}
+
+// scanRecords rebuilds the index by reading every record from the
+// beginning of the file, stopping at EOF or at the separator that may
+// precede an index block.
+func (ss *Reader) scanRecords() error {
+	offset := ss.recordsStart()
+	if _, e := ss.Seek(offset, io.SeekStart); e != nil {
+		return fmt.Errorf("Failed to seek to the start of the records: %v", e)
+	}
+
+	for {
+		key, _, next, _, e := ss.readRecordAt(offset)
+		if e == io.EOF {
+			break
+		}
+		if e == errIsSeparator {
+			break
+		}
+		if e != nil {
+			return fmt.Errorf("Failed to scan record at %d: %v", offset, e)
+		}
+
+		ss.index[key] = append(ss.index[key], offset)
+		offset = next
+	}
+
+	ss.recordsEnd = offset
+	return nil
+}
+
+// errIsSeparator marks that the 4 bytes read where a key-size were
+// actually the 0xffffffff separator that ends the records section.
+var errIsSeparator = fmt.Errorf("record position holds the index separator")
+
+// readRecordAt seeks to offset and reads one (key, value) record,
+// returning the offset just past it as next. deleted reports whether
+// the record is a tombstone written by Writer.Delete, in which case
+// value is always empty.
+func (ss *Reader) readRecordAt(offset int64) (key, value string, next int64, deleted bool, e error) {
+	if _, e = ss.Seek(offset, io.SeekStart); e != nil {
+		return "", "", 0, false, e
+	}
+
+	// Tee the record through a buffer so, for v2 files, its bytes can
+	// be checksummed against the CRC32 that follows it.
+	var body bytes.Buffer
+	tr := io.TeeReader(ss, &body)
+
+	keySize, e := readUint32(tr)
+	if e != nil {
+		return "", "", 0, false, e
+	}
+	if keySize == separator {
+		return "", "", 0, false, errIsSeparator
+	}
+
+	keyBytes := make([]byte, keySize)
+	if _, e = io.ReadFull(tr, keyBytes); e != nil {
+		return "", "", 0, false, e
+	}
+
+	valueSize, e := readUint32(tr)
+	if e != nil {
+		return "", "", 0, false, e
+	}
+
+	var valueBytes []byte
+	if valueSize == deletedValueMarker {
+		deleted = true
+	} else {
+		valueBytes = make([]byte, valueSize)
+		if _, e = io.ReadFull(tr, valueBytes); e != nil {
+			return "", "", 0, false, e
+		}
+	}
+
+	next = offset + int64(body.Len())
+
+	if !ss.legacyV1 {
+		wantCRC, e := readUint32(ss)
+		if e != nil {
+			return "", "", 0, false, e
+		}
+		if crc32.Checksum(body.Bytes(), crcTable) != wantCRC {
+			return "", "", 0, false, &ErrCorrupt{What: fmt.Sprintf("record at offset %d", offset)}
+		}
+		next += 4
+	}
+
+	return string(keyBytes), string(valueBytes), next, deleted, nil
+}
+
+func readKey(r io.Reader) (string, error) {
+	keySize, e := readUint32(r)
+	if e != nil {
+		return "", e
+	}
+	bs := make([]byte, keySize)
+	if _, e := io.ReadFull(r, bs); e != nil {
+		return "", e
+	}
+	return string(bs), nil
+}
+
+// Get returns the latest value written for key.  Since Put allows
+// duplicate keys, this is the value of the last Put call for key.
+func (ss *Reader) Get(key string) (string, error) {
+	if ss.hasBlockIndex {
+		return ss.getBlock(key)
+	}
+	if ss.hasCompactIndex {
+		return ss.LookupCompact(key)
+	}
+
+	offsets, ok := ss.index[key]
+	if !ok || len(offsets) == 0 {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+
+	_, value, _, deleted, e := ss.readRecordAt(offsets[len(offsets)-1])
+	if e != nil {
+		return "", fmt.Errorf("Failed to read value of key %s: %w", key, e)
+	}
+	if deleted {
+		return "", fmt.Errorf("Key not found: %s", key)
+	}
+	return value, nil
+}
+
+// GetAll returns every live value written for key, in the order they
+// were written by Put, skipping any write later removed by
+// Writer.Delete.
+func (ss *Reader) GetAll(key string) ([]string, error) {
+	if ss.hasBlockIndex {
+		return nil, fmt.Errorf("GetAll is not supported by a block index, whose lookups read only the block holding a key's latest write; use Get or Iterator")
+	}
+	if ss.hasCompactIndex {
+		return nil, fmt.Errorf("GetAll is not supported by a compact index, which keeps only the latest value per key; use Get")
+	}
+
+	offsets, ok := ss.index[key]
+	if !ok || len(offsets) == 0 {
+		return nil, fmt.Errorf("Key not found: %s", key)
+	}
+
+	var values []string
+	for _, o := range offsets {
+		_, value, _, deleted, e := ss.readRecordAt(o)
+		if e != nil {
+			return nil, fmt.Errorf("Failed to read value of key %s: %w", key, e)
+		}
+		if deleted {
+			values = nil
+		} else {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("Key not found: %s", key)
+	}
+	return values, nil
+}
+
+// Iterator walks all (key, value) records in a Reader in file order.
+type Iterator struct {
+	ss     *Reader
+	offset int64
+	key    string
+	value  string
+	e      error
+
+	// blockIdx, records and recordIdx replace offset when
+	// ss.hasBlockIndex: blockIdx is the next block to fetch, and
+	// records/recordIdx walk the block currently being read.
+	blockIdx  int
+	records   []blockRecord
+	recordIdx int
+}
+
+// Iterator returns an Iterator that yields every (key, value) record
+// in the SSTable file in the order they were written.
+func (ss *Reader) Iterator() *Iterator {
+	return &Iterator{ss: ss, offset: ss.recordsStart()}
+}
+
+// Next advances the iterator and reports whether a record is
+// available.  Call Key and Value to retrieve it, or Err once Next
+// returns false to find out whether iteration stopped due to an error.
+// Tombstone records written by Writer.Delete are skipped; records
+// written for a key before it was deleted are still yielded.
+func (it *Iterator) Next() bool {
+	if it.ss.hasBlockIndex {
+		return it.nextBlockRecord()
+	}
+
+	for it.e == nil && it.offset < it.ss.recordsEnd {
+		key, value, next, deleted, e := it.ss.readRecordAt(it.offset)
+		if e != nil {
+			it.e = e
+			return false
+		}
+
+		it.offset = next
+		if deleted {
+			continue
+		}
+		it.key, it.value = key, value
+		return true
+	}
+	return false
+}
+
+// nextBlockRecord is Next's block-index counterpart: it walks the
+// records of the block currently loaded, fetching and decompressing
+// the next block, via ss.fetchBlock, once the current one is
+// exhausted, so every record, including duplicate keys split across
+// block boundaries, stays reachable.
+func (it *Iterator) nextBlockRecord() bool {
+	for it.e == nil {
+		for it.recordIdx < len(it.records) {
+			rec := it.records[it.recordIdx]
+			it.recordIdx++
+			if rec.deleted {
+				continue
+			}
+			it.key, it.value = rec.key, rec.value
+			return true
+		}
+
+		if it.blockIdx >= len(it.ss.blockEntries) {
+			return false
+		}
+		records, e := it.ss.fetchBlock(it.ss.blockEntries[it.blockIdx])
+		it.blockIdx++
+		if e != nil {
+			it.e = e
+			return false
+		}
+		it.records = records
+		it.recordIdx = 0
+	}
+	return false
+}
+
+// Key returns the key of the current record.
+func (it *Iterator) Key() string { return it.key }
+
+// Value returns the value of the current record.
+func (it *Iterator) Value() string { return it.value }
+
+// Err returns the error, if any, that stopped iteration early.
+func (it *Iterator) Err() error { return it.e }
+
 func readUint32(r io.Reader) (uint32, error) {
 	var bs [4]byte
 	_, e := r.Read(bs[:])
@@ -0,0 +1,242 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// records is a fixed set of (key, value) pairs, including a duplicate
+// key, written in this order to build test SSTable files of all three
+// variants described in the package doc.
+var records = [][2]string{
+	{"apple", "pie"},
+	{"banana", "split"},
+	{"apple", "imac"},
+}
+
+// buildRecords writes records into w using a Writer and returns the
+// Writer, leaving the caller to decide whether to add a separator
+// and/or an index, along with the file offset at which each Put call
+// started writing its record.
+func buildRecords(w io.Writer) (*Writer, []int64) {
+	ww, e := Create(w)
+	must(e)
+	offsets := make([]int64, len(records))
+	for i, kv := range records {
+		offsets[i] = ww.offset
+		must(ww.Put(kv[0], kv[1]))
+	}
+	return ww, offsets
+}
+
+func TestReaderFileVariants(t *testing.T) {
+	cases := []struct {
+		name string
+		buf  func() []byte
+	}{
+		{
+			name: "records only",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				buildRecords(&buf)
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "records and separator",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				buildRecords(&buf)
+				must(writeUint32(&buf, int(separator)))
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "records and index",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				ww, _ := buildRecords(&buf)
+				must(ww.WriteIndex())
+				return buf.Bytes()
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, e := Open(bytes.NewReader(c.buf()))
+			if e != nil {
+				t.Fatalf("Open failed: %v", e)
+			}
+
+			if v, e := r.Get("banana"); e != nil || v != "split" {
+				t.Errorf("Get(banana) = %q, %v; want split, nil", v, e)
+			}
+
+			if v, e := r.Get("apple"); e != nil || v != "imac" {
+				t.Errorf("Get(apple) = %q, %v; want imac (the latest write), nil", v, e)
+			}
+
+			if _, e := r.Get("missing"); e == nil {
+				t.Errorf("Get(missing) returned no error")
+			}
+
+			all, e := r.GetAll("apple")
+			if e != nil || !reflect.DeepEqual(all, []string{"pie", "imac"}) {
+				t.Errorf("GetAll(apple) = %v, %v; want [pie imac], nil", all, e)
+			}
+
+			it := r.Iterator()
+			var got [][2]string
+			for it.Next() {
+				got = append(got, [2]string{it.Key(), it.Value()})
+			}
+			if e := it.Err(); e != nil {
+				t.Fatalf("Iterator error: %v", e)
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("Iterator yielded %v; want %v", got, records)
+			}
+		})
+	}
+}
+
+// writeLegacyRecords writes records in the original, header-less,
+// checksum-less format, so tests can check that Reader still reads
+// files written before the v2 format existed.
+func writeLegacyRecords(buf *bytes.Buffer) map[string][]int64 {
+	idx := make(map[string][]int64)
+	for _, kv := range records {
+		offset := int64(buf.Len())
+		must(writeUint32(buf, len(kv[0])))
+		buf.WriteString(kv[0])
+		must(writeUint32(buf, len(kv[1])))
+		buf.WriteString(kv[1])
+		idx[kv[0]] = append(idx[kv[0]], offset)
+	}
+	return idx
+}
+
+// writeLegacyIndex appends a legacy, checksum-less index block built
+// from idx, followed by the index-end flag.
+func writeLegacyIndex(buf *bytes.Buffer, idx map[string][]int64) {
+	indexStart := int64(buf.Len())
+	must(writeUint32(buf, int(separator)))
+	must(writeUint32(buf, len(idx)))
+	for k, s := range idx {
+		must(writeUint32(buf, len(k)))
+		buf.WriteString(k)
+		must(writeUint32(buf, len(s)))
+		for _, o := range s {
+			must(writeUint64(buf, o))
+		}
+	}
+	must(writeUint32(buf, int(separator)))
+	must(writeUint64(buf, indexStart))
+}
+
+func TestReaderLegacyV1Variants(t *testing.T) {
+	cases := []struct {
+		name string
+		buf  func() []byte
+	}{
+		{
+			name: "legacy records only",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				writeLegacyRecords(&buf)
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "legacy records and separator",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				writeLegacyRecords(&buf)
+				must(writeUint32(&buf, int(separator)))
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "legacy records and index",
+			buf: func() []byte {
+				var buf bytes.Buffer
+				idx := writeLegacyRecords(&buf)
+				writeLegacyIndex(&buf, idx)
+				return buf.Bytes()
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, e := Open(bytes.NewReader(c.buf()))
+			if e != nil {
+				t.Fatalf("Open failed: %v", e)
+			}
+			if !r.legacyV1 {
+				t.Errorf("Open did not auto-detect the legacy v1 format")
+			}
+
+			if v, e := r.Get("apple"); e != nil || v != "imac" {
+				t.Errorf("Get(apple) = %q, %v; want imac, nil", v, e)
+			}
+
+			it := r.Iterator()
+			var got [][2]string
+			for it.Next() {
+				got = append(got, [2]string{it.Key(), it.Value()})
+			}
+			if e := it.Err(); e != nil {
+				t.Fatalf("Iterator error: %v", e)
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("Iterator yielded %v; want %v", got, records)
+			}
+		})
+	}
+}
+
+func TestReaderV2DetectsCorruptRecord(t *testing.T) {
+	var buf bytes.Buffer
+	ww, offsets := buildRecords(&buf)
+	lastApple := offsets[2] // records[2] is the second, overriding "apple" write.
+	must(ww.WriteIndex())
+
+	// Corrupt a byte inside the last "apple" record's value, well
+	// before the index block, so Open (which loads the index straight
+	// from its footer) succeeds and only a later Get notices.
+	bs := buf.Bytes()
+	bs[lastApple+13] ^= 0xff
+
+	r, e := Open(bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Open failed: %v", e)
+	}
+
+	var ce *ErrCorrupt
+	if _, e := r.Get("apple"); e == nil {
+		t.Fatalf("Get did not detect the corrupted record")
+	} else if !errors.As(e, &ce) {
+		t.Errorf("Get error = %v; want it to wrap *ErrCorrupt", e)
+	}
+}
+
+func TestReaderV2DetectsCorruptIndex(t *testing.T) {
+	var buf bytes.Buffer
+	ww, _ := buildRecords(&buf)
+	must(ww.WriteIndex())
+
+	bs := buf.Bytes()
+	bs[int64(len(bs))-indexEndFlagSize-1] ^= 0xff // flip a bit in the index checksum.
+
+	var ce *ErrCorrupt
+	if _, e := Open(bytes.NewReader(bs)); e == nil {
+		t.Fatalf("Open did not detect the corrupted index")
+	} else if !errors.As(e, &ce) {
+		t.Errorf("Open error = %v; want *ErrCorrupt", e)
+	}
+}
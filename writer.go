@@ -24,105 +24,223 @@
 //     1. 4 bytes of little-endiean encoding of 0xffffffff
 //     2. 8 bytes of the offset of the index.
 //
-// Note that the following are all valid SSTable files:
+// Note that the following are all valid SSTable files written by an
+// older, header-less version of this package, and Reader still reads
+// them (see ReaderOptions.LegacyV1):
 //
 //  1. records
 //  2. records + separator 0xffffffff
 //  3. records + separator 0xffffffff + index + index-end 0xffffffff
 //
+// Since this package's current version, Writer prepends a 4-byte magic
+// number and a 1-byte format version to the file, following the
+// pattern used by Prometheus TSDB's index files, and appends a
+// crc32.Castagnoli checksum after each record's value and after the
+// index block, so that Reader can detect corruption:
+//
+//  1. magic number, 4 bytes of little-endian encoding of 0x5354424c
+//  2. format version, 1 byte, currently 2
+//  3. records, each followed by a 4-byte little-endian CRC32 of the
+//     key-size/key/value-size/value bytes that precede it
+//  4. optionally, separator + index + a 4-byte little-endian CRC32 of
+//     the index block + index-end, as above
+//
+// WriterOptions.BlockSize switches Put and the index to a third,
+// block-compressed layout instead: consecutive records are grouped
+// into ~BlockSize-byte blocks, each compressed independently and
+// indexed by its first key in a block-index footer (see
+// Writer.WriteCompactIndex for the unrelated, non-compressing
+// constant-time hash index, and WriteBlockIndex for this one).
 package sstable
 
 import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
+
+	"github.com/wangkuiyi/sstable/internal/block"
+	"github.com/wangkuiyi/sstable/internal/codec"
 )
 
 const (
 	separator        uint32 = 0xffffffff
 	indexEndFlagSize int64  = 12 // 0xffffff and the 8-byte offset
+
+	magicNumber   uint32 = 0x5354424c // "STBL"
+	formatVersion byte   = 2
+	headerSize    int64  = 5 // 4-byte magic number + 1-byte format version
+
+	// deletedValueMarker stands in for a record's value-size to mark it
+	// as a tombstone written by Writer.Delete: no value bytes follow it,
+	// and Reader treats the key as absent up to and including this
+	// write. Merge honors it by dropping the key from its output.
+	deletedValueMarker uint32 = 0xfffffffe
 )
 
+// crcTable is shared by Writer and Reader to compute and verify the
+// CRC32 checksums of the v2 file format.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 // Writer constructs an SSTable file.  It is not thread-safe.
 type Writer struct {
 	io.Writer
-	index  map[string][]int64
+	idx    indexBuilder
 	offset int64
+
+	// blocks and codec are set instead of idx when WriterOptions.BlockSize
+	// is positive: Put buffers records into blocks rather than indexing
+	// them one by one, and WriteBlockIndex, not WriteIndex, finalizes
+	// the file.
+	blocks *block.Writer
+	codec  codec.Codec
 }
 
-func Create(w io.Writer) *Writer {
-	return &Writer{
-		Writer: w,
-		index:  make(map[string][]int64),
-		offset: 0}
+// Create returns a Writer that writes a v2 SSTable file: the
+// magic-number/version header, followed by whatever records Put
+// writes. It keeps the whole key->offsets index in memory, which is
+// fine for SSTables small enough to fit in RAM; for bigger ones, use
+// NewWriterWithOptions.
+func Create(w io.Writer) (*Writer, error) {
+	return newWriter(w, newMemIndexBuilder())
 }
 
-func (ss *Writer) Put(key, value string) error {
-	// Pack key-size, key, value-size, value into buf, so we can
-	// write buf as a transaction into the SSTable file.
-	var buf bytes.Buffer
+// NewWriterWithOptions is like Create, but with two alternatives to
+// its in-memory index: if opts.BlockSize is positive, it groups
+// records into opts.BlockSize-byte blocks compressed with opts.Codec
+// (WriteBlockIndex, not WriteIndex, must finalize the file); otherwise
+// it spools the key->offsets index to a temp side-file as Put writes
+// records, instead of buffering it in a map, keeping memory use
+// bounded by opts.SortBufferBytes regardless of how many records are
+// written.
+func NewWriterWithOptions(w io.Writer, opts WriterOptions) (*Writer, error) {
+	if opts.BlockSize > 0 {
+		return newBlockWriter(w, opts)
+	}
 
-	if e := writeUint32(&buf, len(key)); e != nil {
-		return fmt.Errorf("Failed to write key size: %v", e)
+	idx, e := newSpoolIndexBuilder(opts)
+	if e != nil {
+		return nil, fmt.Errorf("Failed to create index spool: %v", e)
 	}
+	return newWriter(w, idx)
+}
 
-	if _, e := buf.Write([]byte(key)); e != nil {
-		return fmt.Errorf("Failed to write key: %s", key)
+func newWriter(w io.Writer, idx indexBuilder) (*Writer, error) {
+	ss := &Writer{
+		Writer: w,
+		idx:    idx,
+		offset: headerSize,
+	}
+	if e := writeHeader(ss); e != nil {
+		return nil, fmt.Errorf("Failed to write file header: %v", e)
 	}
+	return ss, nil
+}
 
-	if e := writeUint32(&buf, len(value)); e != nil {
-		return fmt.Errorf("Failed to write value size: %v", e)
+func writeHeader(w io.Writer) error {
+	if e := writeUint32(w, int(magicNumber)); e != nil {
+		return e
 	}
+	_, e := w.Write([]byte{formatVersion})
+	return e
+}
 
-	if _, e := buf.Write([]byte(value)); e != nil {
-		return fmt.Errorf("Failed to write value: %v", e)
+func (ss *Writer) Put(key, value string) error {
+	if ss.blocks != nil {
+		if e := ss.blocks.Add(key, encodeRecordBody(key, value, false)); e != nil {
+			return fmt.Errorf("Failed to buffer key-value pair (%s, %s): %v", key, value, e)
+		}
+		return nil
 	}
+	return ss.writeRecord(key, value, false)
+}
 
-	if _, e := ss.Write(buf.Bytes()); e != nil {
+// Delete writes a tombstone record for key: Get and GetAll on this
+// file treat key as absent from this point on, and Merge drops key
+// entirely from its output once it sees this as the latest write for
+// key across all its sources.
+func (ss *Writer) Delete(key string) error {
+	if ss.blocks != nil {
+		if e := ss.blocks.Add(key, encodeRecordBody(key, "", true)); e != nil {
+			return fmt.Errorf("Failed to buffer tombstone for key %s: %v", key, e)
+		}
+		return nil
+	}
+	return ss.writeRecord(key, "", true)
+}
+
+// encodeRecordBody packs key-size, key, value-size, value (or, for a
+// tombstone, deletedValueMarker in place of value-size and no value
+// bytes), the shared core of both the direct and the block-compressed
+// record formats; only whether (and how) a checksum follows differs.
+func encodeRecordBody(key, value string, tombstone bool) []byte {
+	var buf bytes.Buffer
+	must(writeUint32(&buf, len(key)))
+	buf.WriteString(key)
+	if tombstone {
+		must(writeUint32(&buf, int(deletedValueMarker)))
+	} else {
+		must(writeUint32(&buf, len(value)))
+		buf.WriteString(value)
+	}
+	return buf.Bytes()
+}
+
+// writeRecord writes one direct-format record: encodeRecordBody's
+// bytes, followed by their CRC32 checksum.
+func (ss *Writer) writeRecord(key, value string, tombstone bool) error {
+	buf := encodeRecordBody(key, value, tombstone)
+
+	if _, e := ss.Write(buf); e != nil {
 		return fmt.Errorf("Failed to write key-value pair (%s, %s) to SSTable: %v", key, value, e)
 	}
 
-	ss.index[key] = append(ss.index[key], ss.offset)
-	ss.offset += int64(buf.Len())
+	if e := writeUint32(ss, int(crc32.Checksum(buf, crcTable))); e != nil {
+		return fmt.Errorf("Failed to write checksum of key-value pair (%s, %s): %v", key, value, e)
+	}
+
+	if e := ss.idx.add(key, ss.offset); e != nil {
+		return fmt.Errorf("Failed to record index entry for key %s: %v", key, e)
+	}
+	ss.offset += int64(len(buf)) + 4
 	return nil
 }
 
 func (ss *Writer) WriteIndex() error {
-	if e := writeUint32(ss, int(separator)); e != nil {
-		return fmt.Errorf("Failed to write the separator: %v", e)
+	if ss.idx == nil {
+		return fmt.Errorf("WriteIndex called on a block-compressed Writer; call WriteBlockIndex instead")
 	}
 
-	if e := writeUint32(ss, len(ss.index)); e != nil {
-		return fmt.Errorf("Failed to write the number of unique keys: %v", e)
+	indexStart := ss.offset
+
+	// Tee the index block through a CRC32 hash as it streams out, so
+	// arbitrarily large indexes never need to be buffered in memory
+	// just to be checksummed.
+	crcHash := crc32.New(crcTable)
+	if e := ss.idx.writeBlock(io.MultiWriter(ss, crcHash)); e != nil {
+		return fmt.Errorf("Failed to write index block: %v", e)
 	}
 
-	for k, s := range ss.index {
-		if e := writeUint32(ss, len(k)); e != nil {
-			return fmt.Errorf("Failed to write key size in index: %v", e)
-		}
-		if _, e := ss.Write([]byte(k)); e != nil {
-			return fmt.Errorf("Failed to write key in index: %v", e)
-		}
-		if e := writeUint32(ss, len(s)); e != nil {
-			return fmt.Errorf("Failed to write number of values of key %s: %v", k, e)
-		}
-		for _, o := range s {
-			if e := writeUint64(ss, o); e != nil {
-				return fmt.Errorf("Failed to write offset of key %s: %v", k, e)
-			}
-		}
+	if e := writeUint32(ss, int(crcHash.Sum32())); e != nil {
+		return fmt.Errorf("Failed to write index checksum: %v", e)
 	}
 
 	if e := writeUint32(ss, int(separator)); e != nil {
 		return fmt.Errorf("Failed to write index-end flag: %v", e)
 	}
-	if e := writeUint64(ss, ss.offset); e != nil {
+	if e := writeUint64(ss, indexStart); e != nil {
 		return fmt.Errorf("Failed to write index offset: %v", e)
 	}
 
+	closeErr := ss.idx.close()
+
 	// Note: No more content can be added once we wrote the index.
 	ss.Writer = nil
+
+	if closeErr != nil {
+		return fmt.Errorf("Failed to release index builder resources: %v", closeErr)
+	}
 	return nil
 }
 
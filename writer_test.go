@@ -8,24 +8,25 @@ import (
 
 func TestSSTableCreation(t *testing.T) {
 	var buf bytes.Buffer
-	w := Create(&buf)
+	w, e := Create(&buf)
+	fmt.Println(e)
 	fmt.Println(buf.Len())
 
 	w.Put("", "")
 	fmt.Println(buf.Len())
-	fmt.Println(w.index)
+	fmt.Println(w.offset)
 
 	w.Put("", "")
 	fmt.Println(buf.Len())
-	fmt.Println(w.index)
+	fmt.Println(w.offset)
 
 	w.Put("apple", "pie")
 	fmt.Println(buf.Len())
-	fmt.Println(w.index)
+	fmt.Println(w.offset)
 
 	w.Put("apple", "imac")
 	fmt.Println(buf.Len())
-	fmt.Println(w.index)
+	fmt.Println(w.offset)
 
 	w.WriteIndex()
 	fmt.Println(buf.Len())